@@ -0,0 +1,825 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Generic asset types (registerAssetType is admin only) ====
+// An asset type declares a field schema, the prefix of its keys and the composite key indexes
+// maintained for it. Marbles are the built-in "marble" type, so the generic functions also
+// operate on marbles created by initMarble, and keep its color~name index up to date.
+// Like marbles, an asset of a registered owner is only transferred or deleted by the owner's
+// registrant or an admin.
+// Assets share the simple key namespace with marbles, so key range queries such as
+// getMarblesByRange return them too; choose key prefixes outside the ranges a workload queries.
+//
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["registerAssetType","{\"name\":\"car\",\"keyPrefix\":\"car_\",\"idField\":\"vin\",\"fields\":{\"make\":\"string\",\"year\":\"integer\",\"owner\":\"string\"},\"indexes\":[{\"name\":\"make~vin\",\"fields\":[\"make\"]}]}"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["createAsset","car","vin1","{\"make\":\"fiat\",\"year\":2019,\"owner\":\"tom\"}"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["updateAsset","car","vin1","{\"year\":2020}"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["transferAsset","car","vin1","jerry"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["deleteAsset","car","vin1"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["readAsset","marble","marble1"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["queryAssetsByIndex","marble","color~name","[\"blue\"]","10",""]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["listAssetTypes"]}'
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	// assetTypeObjectType is the docType and composite key object type of registered asset types
+	assetTypeObjectType = "assetType"
+
+	// assetOwnerField is the field changed by transferAsset. Types without it cannot be transferred.
+	assetOwnerField = "owner"
+)
+
+// assetFieldTypes are the types a field of an asset type may have
+var assetFieldTypes = map[string]bool{
+	paramTypeString:  true,
+	paramTypeNumber:  true,
+	paramTypeInteger: true,
+	paramTypeBoolean: true,
+}
+
+// reservedAssetFields are maintained by the chaincode and cannot be declared or set
+var reservedAssetFields = map[string]bool{
+	"docType":   true,
+	"createdAt": true,
+	"updatedAt": true,
+	"lastTxId":  true,
+	"bag":       true, // set on marbles held in a bag
+	"lock":      true, // set on locked marbles
+}
+
+// assetIndex is a composite key index of an asset type. Each asset has one entry keyed by the
+// index name, the values of the indexed fields and the asset ID, like the color~name index.
+type assetIndex struct {
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"`
+}
+
+// assetType describes a kind of asset. Assets are stored under the key prefix followed by their
+// ID, as JSON documents holding the docType, the ID field and every declared field.
+type assetType struct {
+	ObjectType string            `json:"docType"`
+	Name       string            `json:"name"`
+	KeyPrefix  string            `json:"keyPrefix"`
+	IDField    string            `json:"idField"`
+	Fields     map[string]string `json:"fields"` // field name to type; every field is required
+	Indexes    []assetIndex      `json:"indexes"`
+	BuiltIn    bool              `json:"builtIn,omitempty"`
+}
+
+// builtInAssetTypes describe the documents written by the marble functions
+var builtInAssetTypes = map[string]*assetType{
+	"marble": {
+		ObjectType: assetTypeObjectType,
+		Name:       "marble",
+		KeyPrefix:  "",
+		IDField:    "name",
+		Fields:     map[string]string{"color": paramTypeString, "size": paramTypeInteger, "owner": paramTypeString},
+		Indexes:    []assetIndex{{Name: "color~name", Fields: []string{"color"}}},
+		BuiltIn:    true,
+	},
+}
+
+// getAssetType returns a built-in or registered asset type, or nil if it does not exist
+func getAssetType(stub shim.ChaincodeStubInterface, name string) (*assetType, error) {
+	if builtIn, ok := builtInAssetTypes[name]; ok {
+		return builtIn, nil
+	}
+	typeKey, err := stub.CreateCompositeKey(assetTypeObjectType, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	typeAsBytes, err := stub.GetState(typeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get asset type %s: %s", name, err.Error())
+	} else if typeAsBytes == nil {
+		return nil, nil
+	}
+	assetTypeDoc := &assetType{}
+	if err := json.Unmarshal(typeAsBytes, assetTypeDoc); err != nil {
+		return nil, fmt.Errorf("failed to decode asset type %s: %s", name, err.Error())
+	}
+	return assetTypeDoc, nil
+}
+
+// listAssetTypeDocs returns the built-in and registered asset types, sorted by name
+func listAssetTypeDocs(stub shim.ChaincodeStubInterface) ([]*assetType, error) {
+	types := []*assetType{}
+	for _, builtIn := range builtInAssetTypes {
+		types = append(types, builtIn)
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(assetTypeObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		assetTypeDoc := &assetType{}
+		if err := json.Unmarshal(responseRange.Value, assetTypeDoc); err != nil {
+			return nil, err
+		}
+		types = append(types, assetTypeDoc)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	return types, nil
+}
+
+// validateAssetType checks a new asset type against itself and the existing types. Key prefixes
+// must not be prefixes of one another, so that keys of different types never collide.
+func validateAssetType(definition *assetType, existing []*assetType) error {
+	if len(definition.Name) <= 0 {
+		return fmt.Errorf("name must be a non-empty string")
+	}
+	if len(definition.KeyPrefix) <= 0 || !utf8.ValidString(definition.KeyPrefix) || definition.KeyPrefix[0] == 0 {
+		return fmt.Errorf("keyPrefix must be a non-empty UTF-8 string not starting with U+0000")
+	}
+	if len(definition.IDField) <= 0 || reservedAssetFields[definition.IDField] {
+		return fmt.Errorf("idField must be a non-empty string other than docType, createdAt, updatedAt, lastTxId, bag and lock")
+	}
+	if _, ok := definition.Fields[definition.IDField]; ok {
+		return fmt.Errorf("idField %s must not be declared in fields", definition.IDField)
+	}
+	for _, field := range sortedParamNames(definition.Fields) {
+		if len(field) <= 0 || reservedAssetFields[field] {
+			return fmt.Errorf("field name %q is reserved", field)
+		}
+		if !assetFieldTypes[definition.Fields[field]] {
+			return fmt.Errorf("unsupported type %q for field %s", definition.Fields[field], field)
+		}
+	}
+	if fieldType, ok := definition.Fields[assetOwnerField]; ok && fieldType != paramTypeString {
+		return fmt.Errorf("field %s must be of type %s", assetOwnerField, paramTypeString)
+	}
+
+	indexNames := map[string]bool{}
+	for _, other := range existing {
+		if other.Name == definition.Name {
+			return fmt.Errorf("asset type %s already exists", definition.Name)
+		}
+		if other.KeyPrefix != "" && (strings.HasPrefix(definition.KeyPrefix, other.KeyPrefix) || strings.HasPrefix(other.KeyPrefix, definition.KeyPrefix)) {
+			return fmt.Errorf("keyPrefix %q overlaps the key prefix %q of asset type %s", definition.KeyPrefix, other.KeyPrefix, other.Name)
+		}
+		for _, index := range other.Indexes {
+			indexNames[index.Name] = true
+		}
+	}
+	for _, index := range definition.Indexes {
+		// the ~ keeps index entries apart from the chaincode's other composite keys
+		if !strings.Contains(index.Name, "~") {
+			return fmt.Errorf("index name %q must contain a ~, as in color~name", index.Name)
+		}
+		if indexNames[index.Name] {
+			return fmt.Errorf("index %s already exists", index.Name)
+		}
+		indexNames[index.Name] = true
+		if len(index.Fields) == 0 {
+			return fmt.Errorf("index %s must have at least one field", index.Name)
+		}
+		indexed := map[string]bool{}
+		for _, field := range index.Fields {
+			if _, ok := definition.Fields[field]; !ok {
+				return fmt.Errorf("index %s uses undeclared field %s", index.Name, field)
+			}
+			if indexed[field] {
+				return fmt.Errorf("index %s uses field %s twice", index.Name, field)
+			}
+			indexed[field] = true
+		}
+	}
+	return nil
+}
+
+// isMarble reports whether this is the built-in marble type, whose holdings are subject to quotas
+func (a *assetType) isMarble() bool {
+	return a.BuiltIn && a.Name == "marble"
+}
+
+// normalizeAssetFields lower-cases the owner of an asset, and the color of a marble, as
+// initMarble does, so that the marble functions and the color~name index find the asset
+func (a *assetType) normalizeAssetFields(fields map[string]interface{}) {
+	if owner, ok := fields[assetOwnerField].(string); ok {
+		fields[assetOwnerField] = strings.ToLower(owner)
+	}
+	if color, ok := fields["color"].(string); ok && a.isMarble() {
+		fields["color"] = strings.ToLower(color)
+	}
+}
+
+// findAssetIndex returns the index of an asset type with the given name
+func (a *assetType) findAssetIndex(name string) (*assetIndex, bool) {
+	for i := range a.Indexes {
+		if a.Indexes[i].Name == name {
+			return &a.Indexes[i], true
+		}
+	}
+	return nil, false
+}
+
+// checkAssetFields checks field values against the schema of an asset type. Unless partial is
+// set, every declared field must be present.
+func (a *assetType) checkAssetFields(fields map[string]interface{}, partial bool) error {
+	for field, value := range fields {
+		fieldType, ok := a.Fields[field]
+		if !ok {
+			return fmt.Errorf("unknown field %s for asset type %s", field, a.Name)
+		}
+		if err := checkParamType(field, fieldType, value); err != nil {
+			return fmt.Errorf("field %s must be of type %s", field, fieldType)
+		}
+	}
+	if !partial {
+		for _, field := range sortedParamNames(a.Fields) {
+			if _, ok := fields[field]; !ok {
+				return fmt.Errorf("missing field %s", field)
+			}
+		}
+	}
+	return nil
+}
+
+// getAsset reads an asset, or returns nil if there is no asset of this type with the given ID
+func getAsset(stub shim.ChaincodeStubInterface, a *assetType, assetID string) (map[string]interface{}, error) {
+	assetAsBytes, err := stub.GetState(a.KeyPrefix + assetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get asset: %s", err.Error())
+	} else if assetAsBytes == nil {
+		return nil, nil
+	}
+	asset, err := decodeJSONObject(string(assetAsBytes))
+	if err != nil {
+		return nil, err
+	}
+	if asset["docType"] != a.Name {
+		return nil, nil
+	}
+	return asset, nil
+}
+
+// assetIndexValue formats a field value as a composite key attribute
+func assetIndexValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	return fmt.Sprint(value)
+}
+
+// assetIndexKeys returns the index entries of an asset
+func assetIndexKeys(stub shim.ChaincodeStubInterface, a *assetType, assetID string, asset map[string]interface{}) (map[string]bool, error) {
+	keys := map[string]bool{}
+	if asset == nil {
+		return keys, nil
+	}
+	for _, index := range a.Indexes {
+		attributes := make([]string, 0, len(index.Fields)+1)
+		for _, field := range index.Fields {
+			attributes = append(attributes, assetIndexValue(asset[field]))
+		}
+		indexKey, err := stub.CreateCompositeKey(index.Name, append(attributes, assetID))
+		if err != nil {
+			return nil, err
+		}
+		keys[indexKey] = true
+	}
+	return keys, nil
+}
+
+// putAsset stores an asset, stamping it with the transaction's timestamp and ID, and updates
+// its index entries. previous is the stored asset, or nil for a new asset.
+func putAsset(stub shim.ChaincodeStubInterface, a *assetType, assetID string, previous, asset map[string]interface{}) error {
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return fmt.Errorf("failed to get transaction timestamp: %s", err.Error())
+	}
+	asset["docType"] = a.Name
+	asset[a.IDField] = assetID
+	if _, ok := asset["createdAt"]; !ok {
+		asset["createdAt"] = timestamp
+	}
+	asset["updatedAt"] = timestamp
+	asset["lastTxId"] = stub.GetTxID()
+
+	previousKeys, err := assetIndexKeys(stub, a, assetID, previous)
+	if err != nil {
+		return err
+	}
+	keys, err := assetIndexKeys(stub, a, assetID, asset)
+	if err != nil {
+		return err
+	}
+	assetJSONasBytes, err := json.Marshal(asset)
+	if err != nil {
+		return err
+	}
+	if err := stub.PutState(a.KeyPrefix+assetID, assetJSONasBytes); err != nil {
+		return err
+	}
+	for _, indexKey := range sortedKeys(previousKeys) {
+		if !keys[indexKey] {
+			if err := stub.DelState(indexKey); err != nil {
+				return err
+			}
+		}
+	}
+	//  Save index entry to state. Only the key name is needed, no need to store a duplicate copy of the asset.
+	//  Note - passing a 'nil' value will effectively delete the key from state, therefore we pass null character as value
+	for _, indexKey := range sortedKeys(keys) {
+		if !previousKeys[indexKey] {
+			if err := stub.PutState(indexKey, []byte{0x00}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of a set in order, so that state updates are deterministic
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// lookupAssetType returns a built-in or registered asset type, failing if it does not exist
+func lookupAssetType(stub shim.ChaincodeStubInterface, name string) (*assetType, error) {
+	a, err := getAssetType(stub, name)
+	if err != nil {
+		return nil, err
+	} else if a == nil {
+		return nil, fmt.Errorf("asset type %s does not exist", name)
+	}
+	return a, nil
+}
+
+// ==========================================================================================
+// registerAssetType - register a new kind of asset. Asset types cannot be changed once
+// registered, since their documents and index entries are already on the ledger.
+// ==========================================================================================
+func (t *SimpleChaincode) registerAssetType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "{\"name\":\"car\",\"keyPrefix\":\"car_\",\"idField\":\"vin\",\"fields\":{...},\"indexes\":[...]}"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	definition := &assetType{}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(definition); err != nil {
+		return shim.Error("Invalid asset type: " + err.Error())
+	}
+	definition.ObjectType = assetTypeObjectType
+	definition.BuiltIn = false
+	if definition.Fields == nil {
+		definition.Fields = map[string]string{}
+	}
+	if definition.Indexes == nil {
+		definition.Indexes = []assetIndex{}
+	}
+
+	existing, err := listAssetTypeDocs(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := validateAssetType(definition, existing); err != nil {
+		return shim.Error("Invalid asset type: " + err.Error())
+	}
+
+	typeKey, err := stub.CreateCompositeKey(assetTypeObjectType, []string{definition.Name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	typeJSONasBytes, err := json.Marshal(definition)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(typeKey, typeJSONasBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ===========================================================
+// getAssetType - read a built-in or registered asset type
+// ===========================================================
+func (t *SimpleChaincode) getAssetType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the asset type to query")
+	}
+
+	a, err := lookupAssetType(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	typeAsBytes, err := json.Marshal(a)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(typeAsBytes)
+}
+
+// ===========================================================
+// listAssetTypes - list the built-in and registered asset types
+// ===========================================================
+func (t *SimpleChaincode) listAssetTypes(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	types, err := listAssetTypeDocs(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	typesAsBytes, err := json.Marshal(types)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(typesAsBytes)
+}
+
+// ==========================================================================================
+// createAsset - create an asset of the given type. The owner field, if declared, is
+// lower-cased like marble owners and must be registered if the owner policy requires it.
+// The color of a marble is lower-cased too.
+// ==========================================================================================
+func (t *SimpleChaincode) createAsset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1                         2
+	// "car", "vin1", "{\"make\":\"fiat\",\"year\":2019,\"owner\":\"tom\"}"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	a, err := lookupAssetType(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	assetID := args[1]
+	if len(assetID) <= 0 {
+		return shim.Error("2nd argument must be a non-empty string")
+	}
+	fields, err := decodeJSONObject(args[2])
+	if err != nil {
+		return shim.Error("3rd argument must be a JSON object: " + err.Error())
+	}
+	if err := a.checkAssetFields(fields, false); err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Println("- start createAsset ", a.Name, assetID)
+
+	existing, err := stub.GetState(a.KeyPrefix + assetID)
+	if err != nil {
+		return shim.Error("Failed to get asset: " + err.Error())
+	} else if existing != nil {
+		return shim.Error("This asset already exists: " + assetID)
+	}
+	a.normalizeAssetFields(fields)
+	if owner, ok := fields[assetOwnerField].(string); ok {
+		if err := assertOwnerRegistered(stub, owner); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if a.isMarble() {
+		if err := recordMint(stub); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := adjustHoldings(stub, map[string]int{fields[assetOwnerField].(string): 1}); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	if err := putAsset(stub, a, assetID, nil, fields); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end createAsset")
+	return shim.Success(nil)
+}
+
+// ===========================================================
+// readAsset - read an asset of the given type
+// ===========================================================
+func (t *SimpleChaincode) readAsset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "car", "vin1"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	a, err := lookupAssetType(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	assetID := args[1]
+	if len(assetID) <= 0 {
+		return shim.Error("2nd argument must be a non-empty string")
+	}
+
+	asset, err := getAsset(stub, a, assetID)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if asset == nil {
+		return shim.Error("Asset does not exist: " + assetID)
+	}
+	assetAsBytes, err := json.Marshal(asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(assetAsBytes)
+}
+
+// ==========================================================================================
+// updateAsset - set some fields of an asset. The owner can only be changed by transferAsset,
+// so an update never moves a marble out of its owner's bag, but it is refused on locked
+// assets and, for a registered owner, to anyone other than its registrant or an admin.
+// ==========================================================================================
+func (t *SimpleChaincode) updateAsset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1             2
+	// "car", "vin1", "{\"year\":2020}"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	a, err := lookupAssetType(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	assetID := args[1]
+	if len(assetID) <= 0 {
+		return shim.Error("2nd argument must be a non-empty string")
+	}
+	fields, err := decodeJSONObject(args[2])
+	if err != nil {
+		return shim.Error("3rd argument must be a JSON object: " + err.Error())
+	}
+	if err := a.checkAssetFields(fields, true); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, ok := fields[assetOwnerField]; ok {
+		return shim.Error("Use transferAsset to change the owner of an asset")
+	}
+	a.normalizeAssetFields(fields)
+
+	previous, err := getAsset(stub, a, assetID)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if previous == nil {
+		return shim.Error("Asset does not exist: " + assetID)
+	}
+	if err := checkUnlocked(stub, assetID, assetLock(previous)); err != nil {
+		return shim.Error(err.Error())
+	}
+	if owner, ok := previous[assetOwnerField].(string); ok {
+		if err := assertActsFor(stub, owner); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	asset := make(map[string]interface{}, len(previous))
+	for field, value := range previous {
+		asset[field] = value
+	}
+	for field, value := range fields {
+		asset[field] = value
+	}
+
+	if err := putAsset(stub, a, assetID, previous, asset); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ==========================================================================================
+// transferAsset - set a new owner on an asset whose type declares an owner field
+// ==========================================================================================
+func (t *SimpleChaincode) transferAsset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1       2
+	// "car", "vin1", "jerry"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	a, err := lookupAssetType(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	assetID := args[1]
+	if len(assetID) <= 0 {
+		return shim.Error("2nd argument must be a non-empty string")
+	}
+	if _, ok := a.Fields[assetOwnerField]; !ok {
+		return shim.Error("Asset type " + a.Name + " has no owner field")
+	}
+	newOwner := strings.ToLower(args[2])
+	if len(newOwner) <= 0 {
+		return shim.Error("3rd argument must be a non-empty string")
+	}
+	fmt.Println("- start transferAsset ", a.Name, assetID, newOwner)
+	if err := assertOwnerRegistered(stub, newOwner); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	previous, err := getAsset(stub, a, assetID)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if previous == nil {
+		return shim.Error("Asset does not exist: " + assetID)
+	}
+	if bag, ok := previous["bag"].(string); ok && bag != "" {
+		return shim.Error("Asset " + assetID + " is in bag " + bag + "; transfer the bag instead")
+	}
+	if err := checkUnlocked(stub, assetID, assetLock(previous)); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := assertActsFor(stub, assetIndexValue(previous[assetOwnerField])); err != nil {
+		return shim.Error(err.Error())
+	}
+	asset := make(map[string]interface{}, len(previous))
+	for field, value := range previous {
+		asset[field] = value
+	}
+	if a.isMarble() {
+		if err := moveHoldings(stub, assetIndexValue(previous[assetOwnerField]), newOwner); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	asset[assetOwnerField] = newOwner
+
+	if err := putAsset(stub, a, assetID, previous, asset); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end transferAsset (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================
+// deleteAsset - remove an asset and its index entries
+// ===========================================================
+func (t *SimpleChaincode) deleteAsset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "car", "vin1"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	a, err := lookupAssetType(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	assetID := args[1]
+	if len(assetID) <= 0 {
+		return shim.Error("2nd argument must be a non-empty string")
+	}
+
+	asset, err := getAsset(stub, a, assetID)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if asset == nil {
+		return shim.Error("Asset does not exist: " + assetID)
+	}
+	if bag, ok := asset["bag"].(string); ok && bag != "" {
+		return shim.Error("Asset " + assetID + " is in bag " + bag + "; remove it from the bag first")
+	}
+	if err := checkUnlocked(stub, assetID, assetLock(asset)); err != nil {
+		return shim.Error(err.Error())
+	}
+	if owner, ok := asset[assetOwnerField].(string); ok {
+		if err := assertActsFor(stub, owner); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if a.isMarble() {
+		if err := adjustHoldings(stub, map[string]int{assetIndexValue(asset[assetOwnerField]): -1}); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if err := stub.DelState(a.KeyPrefix + assetID); err != nil {
+		return shim.Error("Failed to delete state:" + err.Error())
+	}
+	indexKeys, err := assetIndexKeys(stub, a, assetID, asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, indexKey := range sortedKeys(indexKeys) {
+		if err := stub.DelState(indexKey); err != nil {
+			return shim.Error("Failed to delete state:" + err.Error())
+		}
+	}
+	return shim.Success(nil)
+}
+
+// ==========================================================================================
+// queryAssetsByIndex - read the assets whose leading indexed fields have the given values,
+// in index order, a page at a time if a page size is given. Records are keyed by asset ID.
+// Works on every state database, unlike rich queries.
+// ==========================================================================================
+func (t *SimpleChaincode) queryAssetsByIndex(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0            1              2        3      4
+	// "marble", "color~name", "[\"blue\"]", "10", ""
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+	a, err := lookupAssetType(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	index, ok := a.findAssetIndex(args[1])
+	if !ok {
+		return shim.Error("Asset type " + a.Name + " has no index " + args[1])
+	}
+	values := []string{}
+	if len(args[2]) > 0 {
+		if err := json.Unmarshal([]byte(args[2]), &values); err != nil {
+			return shim.Error("3rd argument must be a JSON array of strings: " + err.Error())
+		}
+	}
+	if len(values) > len(index.Fields) {
+		return shim.Error(fmt.Sprintf("Index %s has %d fields", index.Name, len(index.Fields)))
+	}
+	pageSize := 0
+	if len(args[3]) > 0 {
+		pageSize, err = strconv.Atoi(args[3])
+		if err != nil || pageSize < 0 || pageSize > maxReadMarblesCount {
+			return shim.Error(fmt.Sprintf("4th argument must be a page size between 0 and %d", maxReadMarblesCount))
+		}
+	}
+	bookmark := args[4]
+
+	result := &richQueryResult{Records: []queryRecord{}}
+	var resultsIterator shim.StateQueryIteratorInterface
+	if pageSize > 0 {
+		var responseMetadata *pb.QueryResponseMetadata
+		resultsIterator, responseMetadata, err = stub.GetStateByPartialCompositeKeyWithPagination(index.Name, values, int32(pageSize), bookmark)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Bookmark = responseMetadata.Bookmark
+	} else {
+		resultsIterator, err = stub.GetStateByPartialCompositeKey(index.Name, values)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		assetID := compositeKeyParts[len(compositeKeyParts)-1]
+		assetAsBytes, err := stub.GetState(a.KeyPrefix + assetID)
+		if err != nil {
+			return shim.Error("Failed to get asset: " + err.Error())
+		} else if assetAsBytes == nil {
+			continue
+		}
+		result.Records = append(result.Records, queryRecord{Key: assetID, Record: json.RawMessage(assetAsBytes)})
+	}
+	result.FetchedRecordsCount = int32(len(result.Records))
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
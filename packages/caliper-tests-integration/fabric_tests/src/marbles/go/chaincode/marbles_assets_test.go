@@ -0,0 +1,84 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+
+package chaincode
+
+import (
+	"testing"
+	"time"
+)
+
+// carAssetType is an asset type with an owner field
+const carAssetType = `{"name":"car","keyPrefix":"car_","idField":"vin","fields":{"make":"string","year":"integer","owner":"string"}}`
+
+// newAssetLedger returns a ledger holding car vin1 and marble1 of the registered owner tom
+func newAssetLedger(t *testing.T) *testLedger {
+	l := newTestLedger(t)
+	l.mustInvoke(roleAdmin, "registerAssetType", carAssetType)
+	l.mustInvoke("tom", "registerOwner", "tom", "Tom")
+	l.mustInvoke("tom", "createAsset", "car", "vin1", `{"make":"fiat","year":2019,"owner":"tom"}`)
+	l.mustInvoke("tom", "initMarble", "marble1", "blue", "35", "tom")
+	return l
+}
+
+func TestAssetOwnerAuthorization(t *testing.T) {
+	for _, test := range []struct {
+		function string
+		args     []string
+	}{
+		{function: "transferAsset", args: []string{"car", "vin1", "jerry"}},
+		{function: "deleteAsset", args: []string{"car", "vin1"}},
+		{function: "transferAsset", args: []string{"marble", "marble1", "jerry"}},
+		{function: "deleteAsset", args: []string{"marble", "marble1"}},
+	} {
+		t.Run(test.function+"/"+test.args[0], func(t *testing.T) {
+			l := newAssetLedger(t)
+			l.mustFail("only the registrant of owner tom", "mallory", test.function, test.args...)
+			l.mustInvoke("tom", test.function, test.args...)
+		})
+	}
+}
+
+func TestUpdateMarbleAsset(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		as     string
+		locked bool
+		fields string
+		want   string // error, or empty if the update succeeds
+	}{
+		{name: "color", as: "tom", fields: `{"color":"Red","size":40}`},
+		{name: "owner", as: "tom", fields: `{"owner":"jerry"}`, want: "Use transferAsset"},
+		{name: "non-registrant", as: "mallory", fields: `{"color":"red"}`, want: "only the registrant of owner tom"},
+		{name: "locked", as: "tom", locked: true, fields: `{"color":"red"}`, want: "marble1 is locked"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := newAssetLedger(t)
+			if test.locked {
+				l.now = lockTestTime
+				l.mustInvoke("tom", "lockMarble", "marble1", lockTestTime.Add(time.Hour).Format(time.RFC3339), "escrow")
+			}
+			if test.want != "" {
+				l.mustFail(test.want, test.as, "updateAsset", "marble", "marble1", test.fields)
+				return
+			}
+			l.mustInvoke(test.as, "updateAsset", "marble", "marble1", test.fields)
+			if marbleDoc := readTestMarble(l, "marble1"); marbleDoc.Color != "red" || marbleDoc.Size != 40 || marbleDoc.Owner != "tom" {
+				t.Errorf("got %s marble of size %d owned by %s, expected red of size 40 owned by tom", marbleDoc.Color, marbleDoc.Size, marbleDoc.Owner)
+			}
+			checkColorIndex(t, l.ledger)
+		})
+	}
+}
@@ -0,0 +1,350 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Marble bags ====
+// A bag holds marbles of its owner, which then move with the bag: transferBag changes the
+// owner of the bag and of every marble in it in one transaction, and a marble in a bag cannot
+// be transferred or deleted on its own. The bag functions act for the bag's owner, so the bag
+// of a registered owner is only changed by its registrant or an admin.
+//
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["createBag","bag1","tom"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["addToBag","bag1","marble1"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["removeFromBag","bag1","marble1"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["transferBag","bag1","jerry"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["readBag","bag1"]}'
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	// bagObjectType is the docType and composite key object type of bags
+	bagObjectType = "bag"
+
+	// maxBagMarbles bounds the number of marbles a bag may hold, and so the number of keys
+	// written by transferBag
+	maxBagMarbles = 1000
+)
+
+// bag is a named set of marbles with a single owner
+type bag struct {
+	ObjectType string   `json:"docType"`
+	Name       string   `json:"name"`
+	Owner      string   `json:"owner"`
+	Marbles    []string `json:"marbles"` // sorted marble names
+	CreatedAt  string   `json:"createdAt"`
+	UpdatedAt  string   `json:"updatedAt"`
+	LastTxID   string   `json:"lastTxId"`
+}
+
+// getBag reads a bag, or returns nil if it does not exist
+func getBag(stub shim.ChaincodeStubInterface, bagName string) (*bag, error) {
+	bagKey, err := stub.CreateCompositeKey(bagObjectType, []string{bagName})
+	if err != nil {
+		return nil, err
+	}
+	bagAsBytes, err := stub.GetState(bagKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bag: %s", err.Error())
+	} else if bagAsBytes == nil {
+		return nil, nil
+	}
+	bagDoc := &bag{}
+	if err := json.Unmarshal(bagAsBytes, bagDoc); err != nil {
+		return nil, err
+	}
+	return bagDoc, nil
+}
+
+// putBag stores a bag, stamping it with the transaction's timestamp and ID
+func putBag(stub shim.ChaincodeStubInterface, bagDoc *bag) error {
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return fmt.Errorf("failed to get transaction timestamp: %s", err.Error())
+	}
+	if bagDoc.CreatedAt == "" {
+		bagDoc.CreatedAt = timestamp
+	}
+	bagDoc.UpdatedAt = timestamp
+	bagDoc.LastTxID = stub.GetTxID()
+
+	bagKey, err := stub.CreateCompositeKey(bagObjectType, []string{bagDoc.Name})
+	if err != nil {
+		return err
+	}
+	bagJSONasBytes, err := json.Marshal(bagDoc)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(bagKey, bagJSONasBytes)
+}
+
+// mustGetBag reads a bag, failing if it does not exist
+func mustGetBag(stub shim.ChaincodeStubInterface, bagName string) (*bag, error) {
+	bagDoc, err := getBag(stub, bagName)
+	if err != nil {
+		return nil, err
+	} else if bagDoc == nil {
+		return nil, fmt.Errorf("bag %s does not exist", bagName)
+	}
+	return bagDoc, nil
+}
+
+// getMarble reads a marble, failing if it does not exist
+func getMarble(stub shim.ChaincodeStubInterface, marbleName string) (*marble, error) {
+	marbleAsBytes, err := stub.GetState(marbleName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get marble: %s", err.Error())
+	} else if marbleAsBytes == nil {
+		return nil, fmt.Errorf("marble %s does not exist", marbleName)
+	}
+	if !isMarbleDocument(marbleAsBytes) {
+		return nil, fmt.Errorf("%s is not a marble", marbleName)
+	}
+	marbleDoc := &marble{}
+	if err := json.Unmarshal(marbleAsBytes, marbleDoc); err != nil {
+		return nil, err
+	}
+	return marbleDoc, nil
+}
+
+// putMarble stores a modified marble, stamping it with the transaction's timestamp and ID
+func putMarble(stub shim.ChaincodeStubInterface, marbleDoc *marble) error {
+	var err error
+	marbleDoc.UpdatedAt, err = txTimestamp(stub)
+	if err != nil {
+		return fmt.Errorf("failed to get transaction timestamp: %s", err.Error())
+	}
+	marbleDoc.LastTxID = stub.GetTxID()
+	marbleJSONasBytes, err := json.Marshal(marbleDoc)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(marbleDoc.Name, marbleJSONasBytes)
+}
+
+// ============================================================
+// createBag - create an empty bag for the given owner
+// ============================================================
+func (t *SimpleChaincode) createBag(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "bag1", "tom"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if len(args[0]) <= 0 {
+		return shim.Error("1st argument must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return shim.Error("2nd argument must be a non-empty string")
+	}
+	bagName := args[0]
+	owner := strings.ToLower(args[1])
+	fmt.Println("- start createBag ", bagName, owner)
+
+	existing, err := getBag(stub, bagName)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if existing != nil {
+		return shim.Error("This bag already exists: " + bagName)
+	}
+	if err := assertOwnerRegistered(stub, owner); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := putBag(stub, &bag{ObjectType: bagObjectType, Name: bagName, Owner: owner, Marbles: []string{}}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end createBag")
+	return shim.Success(nil)
+}
+
+// ==========================================================================================
+// addToBag - put a marble into a bag. The marble must have the bag's owner and must not be
+// in another bag.
+// ==========================================================================================
+func (t *SimpleChaincode) addToBag(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0         1
+	// "bag1", "marble1"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	bagName := args[0]
+	marbleName := args[1]
+
+	bagDoc, err := mustGetBag(stub, bagName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := assertActsFor(stub, bagDoc.Owner); err != nil {
+		return shim.Error(err.Error())
+	}
+	marbleDoc, err := getMarble(stub, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if marbleDoc.Bag == bagName {
+		return shim.Error("Marble " + marbleName + " is already in bag " + bagName)
+	} else if marbleDoc.Bag != "" {
+		return shim.Error("Marble " + marbleName + " is in bag " + marbleDoc.Bag)
+	}
+	if marbleDoc.Owner != bagDoc.Owner {
+		return shim.Error("Marble " + marbleName + " is owned by " + marbleDoc.Owner + ", not by the owner of bag " + bagName)
+	}
+	if len(bagDoc.Marbles) >= maxBagMarbles {
+		return shim.Error(fmt.Sprintf("Bag %s is full. A bag holds at most %d marbles", bagName, maxBagMarbles))
+	}
+
+	marbleDoc.Bag = bagName
+	if err := putMarble(stub, marbleDoc); err != nil {
+		return shim.Error(err.Error())
+	}
+	position := sort.SearchStrings(bagDoc.Marbles, marbleName)
+	bagDoc.Marbles = append(bagDoc.Marbles, "")
+	copy(bagDoc.Marbles[position+1:], bagDoc.Marbles[position:])
+	bagDoc.Marbles[position] = marbleName
+	if err := putBag(stub, bagDoc); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// removeFromBag - take a marble out of a bag
+// ============================================================
+func (t *SimpleChaincode) removeFromBag(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0         1
+	// "bag1", "marble1"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	bagName := args[0]
+	marbleName := args[1]
+
+	bagDoc, err := mustGetBag(stub, bagName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := assertActsFor(stub, bagDoc.Owner); err != nil {
+		return shim.Error(err.Error())
+	}
+	position := sort.SearchStrings(bagDoc.Marbles, marbleName)
+	if position == len(bagDoc.Marbles) || bagDoc.Marbles[position] != marbleName {
+		return shim.Error("Marble " + marbleName + " is not in bag " + bagName)
+	}
+	marbleDoc, err := getMarble(stub, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	marbleDoc.Bag = ""
+	if err := putMarble(stub, marbleDoc); err != nil {
+		return shim.Error(err.Error())
+	}
+	bagDoc.Marbles = append(bagDoc.Marbles[:position], bagDoc.Marbles[position+1:]...)
+	if err := putBag(stub, bagDoc); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ==========================================================================================
+// transferBag - set a new owner on a bag and on every marble it holds. Every marble is read
+// and written, so the transaction conflicts with any concurrent change to one of them.
+// ==========================================================================================
+func (t *SimpleChaincode) transferBag(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "bag1", "jerry"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	bagName := args[0]
+	newOwner := strings.ToLower(args[1])
+	if len(newOwner) <= 0 {
+		return shim.Error("2nd argument must be a non-empty string")
+	}
+	fmt.Println("- start transferBag ", bagName, newOwner)
+	if err := assertOwnerRegistered(stub, newOwner); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	bagDoc, err := mustGetBag(stub, bagName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := assertActsFor(stub, bagDoc.Owner); err != nil {
+		return shim.Error(err.Error())
+	}
+	holdings := map[string]int{}
+	for _, marbleName := range bagDoc.Marbles {
+		marbleDoc, err := getMarble(stub, marbleName)
+		if err != nil {
+			return shim.Error("Transfer failed: " + err.Error())
+		}
+		if marbleDoc.Bag != bagName {
+			return shim.Error("Transfer failed: marble " + marbleName + " is not in bag " + bagName)
+		}
+		if err := checkUnlocked(stub, marbleName, marbleDoc.Lock); err != nil {
+			return shim.Error("Transfer failed: " + err.Error())
+		}
+		holdings[marbleDoc.Owner]--
+		holdings[newOwner]++
+		marbleDoc.Owner = newOwner
+		if err := putMarble(stub, marbleDoc); err != nil {
+			return shim.Error("Transfer failed: " + err.Error())
+		}
+	}
+	if err := adjustHoldings(stub, holdings); err != nil {
+		return shim.Error("Transfer failed: " + err.Error())
+	}
+	bagDoc.Owner = newOwner
+	if err := putBag(stub, bagDoc); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("- end transferBag (%d marbles)\n", len(bagDoc.Marbles))
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readBag - read a bag and the names of the marbles it holds
+// ============================================================
+func (t *SimpleChaincode) readBag(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the bag to query")
+	}
+
+	bagDoc, err := mustGetBag(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	bagAsBytes, err := json.Marshal(bagDoc)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bagAsBytes)
+}
@@ -0,0 +1,87 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+
+package chaincode
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// readTestMarble reads a marble through readMarble
+func readTestMarble(l *testLedger, name string) *marble {
+	l.t.Helper()
+	marbleDoc := &marble{}
+	if err := json.Unmarshal(l.mustInvoke("reader", "readMarble", name), marbleDoc); err != nil {
+		l.t.Fatal(err)
+	}
+	return marbleDoc
+}
+
+// newBagLedger returns a ledger holding bag1 of tom with marble1 and marble2, and marble3 of
+// tom outside the bag
+func newBagLedger(t *testing.T) *testLedger {
+	l := newTestLedger(t)
+	l.mustInvoke("tom", "registerOwner", "tom", "Tom")
+	for _, name := range []string{"marble1", "marble2", "marble3"} {
+		l.mustInvoke("tom", "initMarble", name, "blue", "35", "tom")
+	}
+	l.mustInvoke("tom", "createBag", "bag1", "tom")
+	l.mustInvoke("tom", "addToBag", "bag1", "marble1")
+	l.mustInvoke("tom", "addToBag", "bag1", "marble2")
+	return l
+}
+
+func TestTransferBagMovesEveryMarble(t *testing.T) {
+	l := newBagLedger(t)
+	l.mustInvoke("tom", "transferBag", "bag1", "jerry")
+
+	bagDoc := &bag{}
+	if err := json.Unmarshal(l.mustInvoke("reader", "readBag", "bag1"), bagDoc); err != nil {
+		t.Fatal(err)
+	}
+	if bagDoc.Owner != "jerry" || len(bagDoc.Marbles) != 2 {
+		t.Errorf("got bag of %s holding %v, expected jerry's bag holding 2 marbles", bagDoc.Owner, bagDoc.Marbles)
+	}
+	for name, expected := range map[string]string{"marble1": "jerry", "marble2": "jerry", "marble3": "tom"} {
+		if marbleDoc := readTestMarble(l, name); marbleDoc.Owner != expected {
+			t.Errorf("%s is owned by %s, expected %s", name, marbleDoc.Owner, expected)
+		}
+	}
+}
+
+func TestBagRules(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		as       string
+		function string
+		args     []string
+		want     string
+	}{
+		{name: "transfer bagged marble", as: "tom", function: "transferMarble", args: []string{"marble1", "jerry"}, want: "transfer the bag instead"},
+		{name: "add to other bag", as: "tom", function: "addToBag", args: []string{"bag2", "marble1"}, want: "is in bag bag1"},
+		{name: "add other owner's marble", as: "tom", function: "addToBag", args: []string{"bag1", "marble4"}, want: "not by the owner of bag bag1"},
+		{name: "transfer by non-registrant", as: "mallory", function: "transferBag", args: []string{"bag1", "mallory"}, want: "only the registrant of owner tom"},
+		{name: "add by non-registrant", as: "mallory", function: "addToBag", args: []string{"bag1", "marble3"}, want: "only the registrant of owner tom"},
+		{name: "remove by non-registrant", as: "mallory", function: "removeFromBag", args: []string{"bag1", "marble1"}, want: "only the registrant of owner tom"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := newBagLedger(t)
+			l.mustInvoke("tom", "createBag", "bag2", "tom")
+			l.mustInvoke("jerry", "initMarble", "marble4", "red", "50", "jerry")
+			l.mustFail(test.want, test.as, test.function, test.args...)
+		})
+	}
+}
@@ -29,6 +29,7 @@
 
 // ==== Query marbles ====
 // peer chaincode query -C myc1 -n marbles -c '{"Args":["readMarble","marble1"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["readMarbles","[\"marble1\",\"marble2\"]"]}'
 // peer chaincode query -C myc1 -n marbles -c '{"Args":["getMarblesByRange","marble1","marble3"]}'
 // peer chaincode query -C myc1 -n marbles -c '{"Args":["getHistoryForMarble","marble1"]}'
 
@@ -48,8 +49,9 @@
 // CouchDB index JSON syntax as documented at:
 // http://docs.couchdb.org/en/2.1.1/api/database/find.html#db-index
 //
-// This marbles02 example chaincode demonstrates a packaged
-// index which you can find in META-INF/statedb/couchdb/indexes/indexOwner.json.
+// This marbles02 example chaincode demonstrates packaged
+// indexes which you can find in metadata/statedb/couchdb/indexes: indexOwner.json,
+// indexColor.json, indexSizeSortDesc.json and indexOwnerSize.json (see searchMarbles).
 // For deployment of chaincode to production environments, it is recommended
 // to define any indexes alongside chaincode so that the chaincode and supporting indexes
 // are deployed automatically as a unit, once the chaincode has been installed on a peer and
@@ -92,12 +94,12 @@
 // Rich Query with index design doc specified only (Only supported if CouchDB is used as state database):
 //   peer chaincode query -C myc1 -n marbles -c '{"Args":["queryMarbles","{\"selector\":{\"docType\":{\"$eq\":\"marble\"},\"owner\":{\"$eq\":\"tom\"},\"size\":{\"$gt\":0}},\"fields\":[\"docType\",\"owner\",\"size\"],\"sort\":[{\"size\":\"desc\"}],\"use_index\":\"_design/indexSizeSortDoc\"}"]}'
 
-package main
+package chaincode
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -108,24 +110,95 @@ import (
 
 // SimpleChaincode example simple Chaincode implementation
 type SimpleChaincode struct {
+	metrics *chaincodeMetrics // nil unless the metrics endpoint is enabled
+	tracer  *tracer           // nil unless span export is enabled
 }
 
+// unknownFunctionMessage is the error message returned for unknown function names
+const unknownFunctionMessage = "Received unknown function invocation"
+
+// maxReadMarblesCount bounds the number of point reads a single readMarbles query may issue
+const maxReadMarblesCount = 1000
+
 type marble struct {
-	ObjectType string `json:"docType"` //docType is used to distinguish the various types of objects in state database
-	Name       string `json:"name"`    //the fieldtags are needed to keep case from bouncing around
-	Color      string `json:"color"`
-	Size       int    `json:"size"`
-	Owner      string `json:"owner"`
+	ObjectType string      `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	Name       string      `json:"name"`    //the fieldtags are needed to keep case from bouncing around
+	Color      string      `json:"color"`
+	Size       int         `json:"size"`
+	Owner      string      `json:"owner"`
+	CreatedAt  string      `json:"createdAt,omitempty"` //timestamp of the creating transaction, RFC 3339
+	UpdatedAt  string      `json:"updatedAt,omitempty"` //timestamp of the last modifying transaction, RFC 3339
+	LastTxID   string      `json:"lastTxId,omitempty"`  //ID of the last modifying transaction
+	Bag        string      `json:"bag,omitempty"`       //name of the bag holding the marble, if any
+	Lock       *marbleLock `json:"lock,omitempty"`      //lock preventing transfer and deletion, if any
 }
 
-// ===================================================================================
-// Main
-// ===================================================================================
-func main() {
-	err := shim.Start(new(SimpleChaincode))
+// isMarbleDocument reports whether a state value is a marble. Simple keys are shared with the
+// other asset types and any key, composite ones included, can be named directly, so values
+// are checked before they are served or processed as marbles.
+func isMarbleDocument(value []byte) bool {
+	var doc struct {
+		ObjectType string `json:"docType"`
+	}
+	return json.Unmarshal(value, &doc) == nil && doc.ObjectType == "marble"
+}
+
+// txTimestamp returns the timestamp of the transaction proposal in RFC 3339 format. The
+// proposal timestamp is chosen by the client and is the same on every endorsing peer.
+func txTimestamp(stub shim.ChaincodeStubInterface) (string, error) {
+	timestamp, err := stub.GetTxTimestamp()
 	if err != nil {
-		fmt.Printf("Error starting Simple chaincode: %s", err)
+		return "", err
 	}
+	return time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC().Format(time.RFC3339Nano), nil
+}
+
+// readMarblesResult is the response of readMarbles
+type readMarblesResult struct {
+	Marbles []json.RawMessage `json:"marbles"`
+	Missing []string          `json:"missing"`
+}
+
+// paginationMetadata describes a page of the response of a paginated query
+type paginationMetadata struct {
+	RecordsCount string `json:"RecordsCount"`
+	Bookmark     string `json:"Bookmark"`
+}
+
+// paginationMetadataRecord is the last element of the response of a paginated query
+type paginationMetadataRecord struct {
+	ResponseMetadata paginationMetadata `json:"ResponseMetadata"`
+}
+
+// colorTransferResult is the response of transferMarblesBasedOnColor
+type colorTransferResult struct {
+	Color       string   `json:"color"`
+	NewOwner    string   `json:"newOwner"`
+	Transferred int      `json:"transferred"`
+	Skipped     []string `json:"skipped"` // locked marbles left with their owner in skip mode
+}
+
+// historyRecord is one modification of a marble in the response of getHistoryForMarble
+type historyRecord struct {
+	TxId      string          `json:"TxId"`
+	Value     json.RawMessage `json:"Value"` // null for a delete
+	Timestamp string          `json:"Timestamp"`
+	IsDelete  string          `json:"IsDelete"`
+}
+
+// ===================================================================================
+// NewFromEnv returns the chaincode configured from the environment: the metrics
+// endpoint is served if CHAINCODE_METRICS_ADDRESS is set, and spans are exported if
+// CHAINCODE_TRACE_FILE or CHAINCODE_TRACE_ENDPOINT is set
+// ===================================================================================
+func NewFromEnv() *SimpleChaincode {
+	chaincode := new(SimpleChaincode)
+	if address := os.Getenv(metricsAddressEnv); address != "" {
+		chaincode.metrics = newChaincodeMetrics()
+		serveMetrics(address, chaincode.metrics)
+	}
+	chaincode.tracer = newTracerFromEnv()
+	return chaincode
 }
 
 // Init initializes chaincode
@@ -135,38 +208,71 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 }
 
 // Invoke - Our entry point for Invocations
+// Functions are looked up in the function registry (see marbles_registry.go)
 // ========================================
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 	fmt.Println("invoke is running " + function)
 
-	// Handle different functions
-	if function == "initMarble" { //create a new marble
-		return t.initMarble(stub, args)
-	} else if function == "transferMarble" { //change owner of a specific marble
-		return t.transferMarble(stub, args)
-	} else if function == "transferMarblesBasedOnColor" { //transfer all marbles of a certain color
-		return t.transferMarblesBasedOnColor(stub, args)
-	} else if function == "delete" { //delete a marble
-		return t.delete(stub, args)
-	} else if function == "readMarble" { //read a marble
-		return t.readMarble(stub, args)
-	} else if function == "queryMarblesByOwner" { //find marbles for owner X using rich query
-		return t.queryMarblesByOwner(stub, args)
-	} else if function == "queryMarbles" { //find marbles based on an ad hoc rich query
-		return t.queryMarbles(stub, args)
-	} else if function == "getHistoryForMarble" { //get history of values for a marble
-		return t.getHistoryForMarble(stub, args)
-	} else if function == "getMarblesByRange" { //get marbles based on range query
-		return t.getMarblesByRange(stub, args)
-	} else if function == "getMarblesByRangeWithPagination" {
-		return t.getMarblesByRangeWithPagination(stub, args)
-	} else if function == "queryMarblesWithPagination" {
-		return t.queryMarblesWithPagination(stub, args)
-	}
-
-	fmt.Println("invoke did not find func: " + function) //error
-	return shim.Error("Received unknown function invocation")
+	var trace *invocationTrace
+	if t.tracer != nil {
+		trace = t.tracer.start(stub, function)
+		stub = &tracedStub{ChaincodeStubInterface: stub, trace: trace}
+	}
+	var metered *meteredStub
+	if t.metrics != nil {
+		metered = newMeteredStub(stub)
+		stub = metered
+	}
+	dryRun, err := isDryRun(stub)
+	if err != nil {
+		return shim.Error("Invalid " + dryRunTransientKey + " flag: " + err.Error())
+	}
+	var recorder *dryRunStub
+	if dryRun {
+		recorder = newDryRunStub(stub)
+		stub = recorder
+	}
+
+	stub = newQuotaStub(stub)
+
+	start := time.Now()
+	response := t.dispatch(stub, function, args)
+	if recorder != nil {
+		resultAsBytes, err := recorder.result(function, response)
+		if err != nil {
+			response = shim.Error(err.Error())
+		} else {
+			response = shim.Success(resultAsBytes)
+		}
+	}
+
+	if metered != nil {
+		label := function
+		if _, ok := lookupFunction(function); !ok {
+			label = unknownFunction
+		}
+		t.metrics.observe(label, response, time.Since(start), metered.ops)
+	}
+	if trace != nil {
+		trace.finish(response)
+	}
+	return response
+}
+
+// dispatch calls the registered chaincode function with the given name
+func (t *SimpleChaincode) dispatch(stub shim.ChaincodeStubInterface, function string, args []string) pb.Response {
+	spec, ok := lookupFunction(function)
+	if !ok {
+		fmt.Println("invoke did not find func: " + function) //error
+		return shim.Error(unknownFunctionMessage)
+	}
+	if len(spec.Roles) > 0 {
+		if err := assertAnyRole(stub, spec.Roles); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	return spec.handler(t, stub, args)
 }
 
 // ============================================================
@@ -211,10 +317,23 @@ func (t *SimpleChaincode) initMarble(stub shim.ChaincodeStubInterface, args []st
 		fmt.Println("This marble already exists: " + marbleName)
 		return shim.Error("This marble already exists: " + marbleName)
 	}
+	if err := assertOwnerRegistered(stub, owner); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := recordMint(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := adjustHoldings(stub, map[string]int{owner: 1}); err != nil {
+		return shim.Error(err.Error())
+	}
 
 	// ==== Create marble object and marshal to JSON ====
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error("Failed to get transaction timestamp: " + err.Error())
+	}
 	objectType := "marble"
-	marble := &marble{objectType, marbleName, color, size, owner}
+	marble := &marble{objectType, marbleName, color, size, owner, timestamp, timestamp, stub.GetTxID(), "", nil}
 	marbleJSONasBytes, err := json.Marshal(marble)
 	if err != nil {
 		return shim.Error(err.Error())
@@ -268,11 +387,64 @@ func (t *SimpleChaincode) readMarble(stub shim.ChaincodeStubInterface, args []st
 	} else if valAsbytes == nil {
 		jsonResp = "{\"Error\":\"Marble does not exist: " + name + "\"}"
 		return shim.Error(jsonResp)
+	} else if !isMarbleDocument(valAsbytes) {
+		jsonResp = "{\"Error\":\"Not a marble: " + name + "\"}"
+		return shim.Error(jsonResp)
 	}
 
 	return shim.Success(valAsbytes)
 }
 
+// ======================================================================================
+// readMarbles - read a set of marbles from chaincode state in a single query.
+// The response lists the marbles that were found and the names that do not exist.
+// ======================================================================================
+func (t *SimpleChaincode) readMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "[\"marble1\",\"marble2\"]"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting a JSON array of marble names")
+	}
+
+	var names []string
+	err := json.Unmarshal([]byte(args[0]), &names)
+	if err != nil {
+		return shim.Error("1st argument must be a JSON array of strings: " + err.Error())
+	}
+	if len(names) > maxReadMarblesCount {
+		return shim.Error(fmt.Sprintf("Too many marble names. Expecting at most %d", maxReadMarblesCount))
+	}
+
+	result := readMarblesResult{Marbles: []json.RawMessage{}, Missing: []string{}}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if len(name) <= 0 {
+			result.Missing = append(result.Missing, name)
+			continue
+		}
+		valAsbytes, err := stub.GetState(name)
+		if err != nil {
+			return shim.Error("Failed to get state for " + name + ": " + err.Error())
+		} else if valAsbytes == nil || !isMarbleDocument(valAsbytes) {
+			result.Missing = append(result.Missing, name)
+			continue
+		}
+		result.Marbles = append(result.Marbles, json.RawMessage(valAsbytes))
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
+
 // ==================================================
 // delete - remove a marble key/value pair from state
 // ==================================================
@@ -299,6 +471,18 @@ func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string
 		jsonResp = "{\"Error\":\"Failed to decode JSON of: " + marbleName + "\"}"
 		return shim.Error(jsonResp)
 	}
+	if marbleJSON.Bag != "" {
+		return shim.Error("Marble " + marbleName + " is in bag " + marbleJSON.Bag + "; remove it from the bag first")
+	}
+	if err := checkUnlocked(stub, marbleName, marbleJSON.Lock); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := assertActsFor(stub, marbleJSON.Owner); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := adjustHoldings(stub, map[string]int{marbleJSON.Owner: -1}); err != nil {
+		return shim.Error(err.Error())
+	}
 
 	err = stub.DelState(marbleName) //remove the marble from chaincode state
 	if err != nil {
@@ -335,23 +519,14 @@ func (t *SimpleChaincode) transferMarble(stub shim.ChaincodeStubInterface, args
 	newOwner := strings.ToLower(args[1])
 	fmt.Println("- start transferMarble ", marbleName, newOwner)
 
-	marbleAsBytes, err := stub.GetState(marbleName)
+	marbleToTransfer, err := getMarble(stub, marbleName)
 	if err != nil {
-		return shim.Error("Failed to get marble:" + err.Error())
-	} else if marbleAsBytes == nil {
-		return shim.Error("Marble does not exist")
+		return shim.Error(err.Error())
 	}
-
-	marbleToTransfer := marble{}
-	err = json.Unmarshal(marbleAsBytes, &marbleToTransfer) //unmarshal it aka JSON.parse()
-	if err != nil {
+	if err := assertActsFor(stub, marbleToTransfer.Owner); err != nil {
 		return shim.Error(err.Error())
 	}
-	marbleToTransfer.Owner = newOwner //change the owner
-
-	marbleJSONasBytes, _ := json.Marshal(marbleToTransfer)
-	err = stub.PutState(marbleName, marbleJSONasBytes) //rewrite the marble
-	if err != nil {
+	if err := moveMarble(stub, marbleToTransfer, newOwner); err != nil {
 		return shim.Error(err.Error())
 	}
 
@@ -359,57 +534,60 @@ func (t *SimpleChaincode) transferMarble(stub shim.ChaincodeStubInterface, args
 	return shim.Success(nil)
 }
 
+// moveMarble sets a new owner on a marble read in this transaction. It checks the state of
+// the marble, but not the invoker: callers authorize the move first, e.g. with assertActsFor
+// or by settling a sale or an accepted offer.
+func moveMarble(stub shim.ChaincodeStubInterface, marbleToTransfer *marble, newOwner string) error {
+	if err := assertOwnerRegistered(stub, newOwner); err != nil {
+		return err
+	}
+	if marbleToTransfer.Bag != "" {
+		return fmt.Errorf("marble %s is in bag %s; transfer the bag instead", marbleToTransfer.Name, marbleToTransfer.Bag)
+	}
+	if err := checkUnlocked(stub, marbleToTransfer.Name, marbleToTransfer.Lock); err != nil {
+		return err
+	}
+	if err := moveHoldings(stub, marbleToTransfer.Owner, newOwner); err != nil {
+		return err
+	}
+	marbleToTransfer.Owner = newOwner //change the owner
+	return putMarble(stub, marbleToTransfer)
+}
+
 // ===========================================================================================
 // constructQueryResponseFromIterator constructs a JSON array containing query results from
-// a given result iterator
+// a given result iterator. Keys are JSON-escaped, so any marble name yields a valid response.
 // ===========================================================================================
-func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface) (*bytes.Buffer, error) {
-	// buffer is a JSON array containing QueryResults
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface) ([]byte, error) {
+	records, err := collectQueryRecords(resultsIterator)
+	if err != nil {
+		return nil, err
 	}
-	buffer.WriteString("]")
-
-	return &buffer, nil
+	return json.Marshal(records)
 }
 
 // ===========================================================================================
-// addPaginationMetadataToQueryResults adds QueryResponseMetadata, which contains pagination
-// info, to the constructed query results
+// constructPaginatedQueryResponseFromIterator constructs a JSON array containing a page of
+// query results followed by the pagination metadata (record count and bookmark of the next
+// page). The metadata used to be written as a second array after the first one; it is now the
+// last element of the same array, so that the response is valid JSON.
 // ===========================================================================================
-func addPaginationMetadataToQueryResults(buffer *bytes.Buffer, responseMetadata *pb.QueryResponseMetadata) *bytes.Buffer {
-
-	buffer.WriteString("[{\"ResponseMetadata\":{\"RecordsCount\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(fmt.Sprintf("%v", responseMetadata.FetchedRecordsCount))
-	buffer.WriteString("\"")
-	buffer.WriteString(", \"Bookmark\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(responseMetadata.Bookmark)
-	buffer.WriteString("\"}}]")
-
-	return buffer
+func constructPaginatedQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface, responseMetadata *pb.QueryResponseMetadata) ([]byte, error) {
+	records, err := collectQueryRecords(resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+	response := make([]interface{}, 0, len(records)+1)
+	for _, record := range records {
+		response = append(response, record)
+	}
+	response = append(response, &paginationMetadataRecord{
+		ResponseMetadata: paginationMetadata{
+			RecordsCount: fmt.Sprintf("%v", responseMetadata.FetchedRecordsCount),
+			Bookmark:     responseMetadata.Bookmark,
+		},
+	})
+	return json.Marshal(response)
 }
 
 // ===========================================================================================
@@ -438,14 +616,14 @@ func (t *SimpleChaincode) getMarblesByRange(stub shim.ChaincodeStubInterface, ar
 	}
 	defer resultsIterator.Close()
 
-	buffer, err := constructQueryResponseFromIterator(resultsIterator)
+	queryResults, err := constructQueryResponseFromIterator(resultsIterator)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	fmt.Printf("- getMarblesByRange queryResult:\n%s\n", buffer.String())
+	fmt.Printf("- getMarblesByRange queryResult:\n%s\n", queryResults)
 
-	return shim.Success(buffer.Bytes())
+	return shim.Success(queryResults)
 }
 
 // ==== Example: GetStateByPartialCompositeKey/RangeQuery =========================================
@@ -455,18 +633,30 @@ func (t *SimpleChaincode) getMarblesByRange(stub shim.ChaincodeStubInterface, ar
 // between endorsement time and commit time. The transaction is invalidated by the
 // committing peers if the result set has changed between endorsement time and commit time.
 // Therefore, range queries are a safe option for performing update transactions based on query results.
+// Locked marbles fail the transaction, unless the optional mode is "skip".
 // ===========================================================================================
 func (t *SimpleChaincode) transferMarblesBasedOnColor(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	//   0       1
-	// "color", "bob"
-	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	//   0       1       2
+	// "color", "bob", "skip"
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
 	}
 
 	color := args[0]
 	newOwner := strings.ToLower(args[1])
+	mode := colorTransferReject
+	if len(args) == 3 && len(args[2]) > 0 {
+		mode = args[2]
+	}
+	if mode != colorTransferReject && mode != colorTransferSkip {
+		return shim.Error("3rd argument must be " + colorTransferReject + " or " + colorTransferSkip)
+	}
 	fmt.Println("- start transferMarblesBasedOnColor ", color, newOwner)
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	// Query the color~name index by color
 	// This will execute a key range query on all keys starting with 'color'
@@ -477,8 +667,8 @@ func (t *SimpleChaincode) transferMarblesBasedOnColor(stub shim.ChaincodeStubInt
 	defer coloredMarbleResultsIterator.Close()
 
 	// Iterate through result set and for each marble found, transfer to newOwner
-	var i int
-	for i = 0; coloredMarbleResultsIterator.HasNext(); i++ {
+	result := &colorTransferResult{Color: color, NewOwner: newOwner, Skipped: []string{}}
+	for coloredMarbleResultsIterator.HasNext() {
 		// Note that we don't get the value (2nd return variable), we'll just get the marble name from the composite key
 		responseRange, err := coloredMarbleResultsIterator.Next()
 		if err != nil {
@@ -494,18 +684,32 @@ func (t *SimpleChaincode) transferMarblesBasedOnColor(stub shim.ChaincodeStubInt
 		returnedMarbleName := compositeKeyParts[1]
 		fmt.Printf("- found a marble from index:%s color:%s name:%s\n", objectType, returnedColor, returnedMarbleName)
 
-		// Now call the transfer function for the found marble.
-		// Re-use the same function that is used to transfer individual marbles
-		response := t.transferMarble(stub, []string{returnedMarbleName, newOwner})
-		// if the transfer failed break out of loop and return error
-		if response.Status != shim.OK {
-			return shim.Error("Transfer failed: " + response.Message)
+		marbleDoc, err := getMarble(stub, returnedMarbleName)
+		if err != nil {
+			return shim.Error("Transfer failed: " + err.Error())
+		}
+		if mode == colorTransferSkip && marbleDoc.Lock.active(now) {
+			result.Skipped = append(result.Skipped, returnedMarbleName)
+			continue
 		}
+
+		// Now transfer the found marble, if the invoker may act for its owner.
+		// If the transfer failed break out of loop and return error
+		if err := assertActsFor(stub, marbleDoc.Owner); err != nil {
+			return shim.Error("Transfer failed: " + err.Error())
+		}
+		if err := moveMarble(stub, marbleDoc, newOwner); err != nil {
+			return shim.Error("Transfer failed: " + err.Error())
+		}
+		result.Transferred++
 	}
 
-	responsePayload := fmt.Sprintf("Transferred %d %s marbles to %s", i, color, newOwner)
-	fmt.Println("- end transferMarblesBasedOnColor: " + responsePayload)
-	return shim.Success([]byte(responsePayload))
+	responsePayload, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Println("- end transferMarblesBasedOnColor: " + string(responsePayload))
+	return shim.Success(responsePayload)
 }
 
 // =======Rich queries =========================================================================
@@ -537,9 +741,16 @@ func (t *SimpleChaincode) queryMarblesByOwner(stub shim.ChaincodeStubInterface,
 
 	owner := strings.ToLower(args[0])
 
-	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"marble\",\"owner\":\"%s\"}}", owner)
+	// Build the query from Go values so that the owner is JSON encoded and cannot alter the selector
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{"docType": "marble", "owner": owner},
+	}
+	queryString, err := json.Marshal(query)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	queryResults, err := getQueryResultForQueryString(stub, queryString)
+	queryResults, err := getQueryResultForQueryString(stub, string(queryString))
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -548,7 +759,8 @@ func (t *SimpleChaincode) queryMarblesByOwner(stub shim.ChaincodeStubInterface,
 
 // ===== Example: Ad hoc rich query ========================================================
 // queryMarbles uses a query string to perform a query for marbles.
-// Query string matching state database syntax is passed in and executed as is.
+// Query string matching state database syntax is passed in and checked against the
+// query policy (see setQueryPolicy) before it is executed.
 // Supports ad hoc queries that can be defined at runtime by the client.
 // If this is not desired, follow the queryMarblesForOwner example for parameterized queries.
 // Only available on state databases that support rich query (e.g. CouchDB)
@@ -561,7 +773,14 @@ func (t *SimpleChaincode) queryMarbles(stub shim.ChaincodeStubInterface, args []
 		return shim.Error("Incorrect number of arguments. Expecting 1")
 	}
 
-	queryString := args[0]
+	policy, err := getQueryPolicy(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	queryString, _, err := policy.apply(args[0], false, 0)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	queryResults, err := getQueryResultForQueryString(stub, queryString)
 	if err != nil {
@@ -584,14 +803,14 @@ func getQueryResultForQueryString(stub shim.ChaincodeStubInterface, queryString
 	}
 	defer resultsIterator.Close()
 
-	buffer, err := constructQueryResponseFromIterator(resultsIterator)
+	queryResults, err := constructQueryResponseFromIterator(resultsIterator)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Printf("- getQueryResultForQueryString queryResult:\n%s\n", buffer.String())
+	fmt.Printf("- getQueryResultForQueryString queryResult:\n%s\n", queryResults)
 
-	return buffer.Bytes(), nil
+	return queryResults, nil
 }
 
 // ====== Pagination =========================================================================
@@ -634,21 +853,20 @@ func (t *SimpleChaincode) getMarblesByRangeWithPagination(stub shim.ChaincodeStu
 	}
 	defer resultsIterator.Close()
 
-	buffer, err := constructQueryResponseFromIterator(resultsIterator)
+	queryResults, err := constructPaginatedQueryResponseFromIterator(resultsIterator, responseMetadata)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	bufferWithPaginationInfo := addPaginationMetadataToQueryResults(buffer, responseMetadata)
+	fmt.Printf("- getMarblesByRange queryResult:\n%s\n", queryResults)
 
-	fmt.Printf("- getMarblesByRange queryResult:\n%s\n", bufferWithPaginationInfo.String())
-
-	return shim.Success(buffer.Bytes())
+	return shim.Success(queryResults)
 }
 
 // ===== Example: Pagination with Ad hoc Rich Query ========================================================
 // queryMarblesWithPagination uses a query string, page size and a bookmark to perform a query
-// for marbles. Query string matching state database syntax is passed in and executed as is.
+// for marbles. Query string matching state database syntax is passed in and checked against
+// the query policy (see setQueryPolicy) before it is executed.
 // The number of fetched records would be equal to or lesser than the specified page size.
 // Supports ad hoc queries that can be defined at runtime by the client.
 // If this is not desired, follow the queryMarblesForOwner example for parameterized queries.
@@ -663,15 +881,23 @@ func (t *SimpleChaincode) queryMarblesWithPagination(stub shim.ChaincodeStubInte
 		return shim.Error("Incorrect number of arguments. Expecting 3")
 	}
 
-	queryString := args[0]
 	//return type of ParseInt is int64
-	pageSize, err := strconv.ParseInt(args[1], 10, 32)
+	requestedPageSize, err := strconv.ParseInt(args[1], 10, 32)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	bookmark := args[2]
 
-	queryResults, err := getQueryResultForQueryStringWithPagination(stub, queryString, int32(pageSize), bookmark)
+	policy, err := getQueryPolicy(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	queryString, pageSize, err := policy.apply(args[0], true, int32(requestedPageSize))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	queryResults, err := getQueryResultForQueryStringWithPagination(stub, queryString, pageSize, bookmark)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -692,16 +918,14 @@ func getQueryResultForQueryStringWithPagination(stub shim.ChaincodeStubInterface
 	}
 	defer resultsIterator.Close()
 
-	buffer, err := constructQueryResponseFromIterator(resultsIterator)
+	queryResults, err := constructPaginatedQueryResponseFromIterator(resultsIterator, responseMetadata)
 	if err != nil {
 		return nil, err
 	}
 
-	bufferWithPaginationInfo := addPaginationMetadataToQueryResults(buffer, responseMetadata)
-
-	fmt.Printf("- getQueryResultForQueryString queryResult:\n%s\n", bufferWithPaginationInfo.String())
+	fmt.Printf("- getQueryResultForQueryString queryResult:\n%s\n", queryResults)
 
-	return buffer.Bytes(), nil
+	return queryResults, nil
 }
 
 func (t *SimpleChaincode) getHistoryForMarble(stub shim.ChaincodeStubInterface, args []string) pb.Response {
@@ -720,51 +944,35 @@ func (t *SimpleChaincode) getHistoryForMarble(stub shim.ChaincodeStubInterface,
 	}
 	defer resultsIterator.Close()
 
-	// buffer is a JSON array containing historic values for the marble
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
+	// records is a JSON array containing historic values for the marble
+	records := []historyRecord{}
 	for resultsIterator.HasNext() {
 		response, err := resultsIterator.Next()
 		if err != nil {
 			return shim.Error(err.Error())
 		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"TxId\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(response.TxId)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Value\":")
 		// if it was a delete operation on given key, then we need to set the
 		//corresponding value null. Else, we will write the response.Value
 		//as-is (as the Value itself a JSON marble)
-		if response.IsDelete {
-			buffer.WriteString("null")
-		} else {
-			buffer.WriteString(string(response.Value))
+		record := historyRecord{
+			TxId:      response.TxId,
+			Timestamp: time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String(),
+			IsDelete:  strconv.FormatBool(response.IsDelete),
 		}
-
-		buffer.WriteString(", \"Timestamp\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String())
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"IsDelete\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(strconv.FormatBool(response.IsDelete))
-		buffer.WriteString("\"")
-
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+		if !response.IsDelete {
+			if !isMarbleDocument(response.Value) {
+				return shim.Error("Not a marble: " + marbleName)
+			}
+			record.Value = json.RawMessage(response.Value)
+		}
+		records = append(records, record)
+	}
+	historyAsBytes, err := json.Marshal(records)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	buffer.WriteString("]")
 
-	fmt.Printf("- getHistoryForMarble returning:\n%s\n", buffer.String())
+	fmt.Printf("- getHistoryForMarble returning:\n%s\n", historyAsBytes)
 
-	return shim.Success(buffer.Bytes())
+	return shim.Success(historyAsBytes)
 }
@@ -0,0 +1,178 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Chaincode-side cost benchmarks ====
+// Every function runs through Invoke against an in-memory ledger, so the numbers cover argument
+// parsing, JSON handling, state access and response building, but no peer or network overhead.
+// Simulated transactions are not committed, so each iteration sees the same dataset.
+//
+// go test -run '^$' -bench . -benchmem
+// go test -run '^$' -bench 'TransferMarblesBasedOnColor/marbles=1000' -benchmem -count 10
+
+package chaincode
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-samples/chaincode/marbles02/go/internal/memstub"
+)
+
+// benchDatasetSizes are the numbers of marbles on the ledger for each sub-benchmark
+var benchDatasetSizes = []int{10, 100, 1000}
+
+// benchPrefix is the name prefix of the seeded marbles
+const benchPrefix = "marble"
+
+// silenceStdout discards the chaincode's logging for the duration of a benchmark or fuzz test.
+// The writes still happen, as they do on a peer, but do not flood the test output.
+func silenceStdout(tb testing.TB) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	tb.Cleanup(func() {
+		os.Stdout = stdout
+		devNull.Close()
+	})
+}
+
+// newBenchLedger returns a ledger holding count seeded marbles
+func newBenchLedger(b *testing.B, chaincode *SimpleChaincode, count int) *memstub.Ledger {
+	ledger := memstub.NewLedger("bench")
+	response := ledger.Invoke(chaincode, memstub.Proposal{
+		Function: "seedMarbles",
+		Args:     []string{benchPrefix, strconv.Itoa(count), "42", "", ""},
+	})
+	if response.Status != shim.OK {
+		b.Fatalf("seedMarbles failed: %s", response.Message)
+	}
+	return ledger
+}
+
+// benchInvoke simulates one invocation per iteration without committing it, and reports the
+// average size of the response payload. args returns the arguments of the i-th iteration.
+func benchInvoke(b *testing.B, chaincode *SimpleChaincode, ledger *memstub.Ledger, function string, args func(i int) []string) {
+	proposals := make([]memstub.Proposal, b.N)
+	for i := range proposals {
+		proposals[i] = memstub.Proposal{Function: function, Args: args(i)}
+	}
+
+	payloadBytes := 0
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		response := chaincode.Invoke(ledger.NewStub(proposals[i]))
+		if response.Status != shim.OK {
+			b.Fatalf("%s failed: %s", function, response.Message)
+		}
+		payloadBytes += len(response.Payload)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(payloadBytes)/float64(b.N), "payload-B/op")
+}
+
+// benchEachSize runs fn as a sub-benchmark for each dataset size
+func benchEachSize(b *testing.B, fn func(b *testing.B, chaincode *SimpleChaincode, ledger *memstub.Ledger, count int)) {
+	for _, count := range benchDatasetSizes {
+		b.Run("marbles="+strconv.Itoa(count), func(b *testing.B) {
+			silenceStdout(b)
+			chaincode := new(SimpleChaincode)
+			fn(b, chaincode, newBenchLedger(b, chaincode, count), count)
+		})
+	}
+}
+
+func BenchmarkInitMarble(b *testing.B) {
+	benchEachSize(b, func(b *testing.B, chaincode *SimpleChaincode, ledger *memstub.Ledger, count int) {
+		benchInvoke(b, chaincode, ledger, "initMarble", func(i int) []string {
+			return []string{"new" + strconv.Itoa(i), "blue", "35", "tom"}
+		})
+	})
+}
+
+func BenchmarkReadMarble(b *testing.B) {
+	benchEachSize(b, func(b *testing.B, chaincode *SimpleChaincode, ledger *memstub.Ledger, count int) {
+		benchInvoke(b, chaincode, ledger, "readMarble", func(i int) []string {
+			return []string{benchPrefix + strconv.Itoa(i%count)}
+		})
+	})
+}
+
+func BenchmarkTransferMarble(b *testing.B) {
+	benchEachSize(b, func(b *testing.B, chaincode *SimpleChaincode, ledger *memstub.Ledger, count int) {
+		benchInvoke(b, chaincode, ledger, "transferMarble", func(i int) []string {
+			return []string{benchPrefix + strconv.Itoa(i%count), "jerry"}
+		})
+	})
+}
+
+func BenchmarkTransferMarblesBasedOnColor(b *testing.B) {
+	benchEachSize(b, func(b *testing.B, chaincode *SimpleChaincode, ledger *memstub.Ledger, count int) {
+		benchInvoke(b, chaincode, ledger, "transferMarblesBasedOnColor", func(i int) []string {
+			return []string{"blue", "jerry"}
+		})
+	})
+}
+
+func BenchmarkDelete(b *testing.B) {
+	benchEachSize(b, func(b *testing.B, chaincode *SimpleChaincode, ledger *memstub.Ledger, count int) {
+		benchInvoke(b, chaincode, ledger, "delete", func(i int) []string {
+			return []string{benchPrefix + strconv.Itoa(i%count)}
+		})
+	})
+}
+
+func BenchmarkGetMarblesByRange(b *testing.B) {
+	benchEachSize(b, func(b *testing.B, chaincode *SimpleChaincode, ledger *memstub.Ledger, count int) {
+		benchInvoke(b, chaincode, ledger, "getMarblesByRange", func(i int) []string {
+			return []string{"", ""}
+		})
+	})
+}
+
+func BenchmarkGetMarblesByRangeWithPagination(b *testing.B) {
+	benchEachSize(b, func(b *testing.B, chaincode *SimpleChaincode, ledger *memstub.Ledger, count int) {
+		benchInvoke(b, chaincode, ledger, "getMarblesByRangeWithPagination", func(i int) []string {
+			return []string{"", "", "10", ""}
+		})
+	})
+}
+
+// BenchmarkGetHistoryForMarble reads a marble with one modification per dataset size
+func BenchmarkGetHistoryForMarble(b *testing.B) {
+	for _, count := range benchDatasetSizes {
+		b.Run("modifications="+strconv.Itoa(count), func(b *testing.B) {
+			silenceStdout(b)
+			chaincode := new(SimpleChaincode)
+			ledger := newBenchLedger(b, chaincode, 1)
+			for i := 1; i < count; i++ {
+				response := ledger.Invoke(chaincode, memstub.Proposal{
+					Function: "transferMarble",
+					Args:     []string{benchPrefix + "0", "owner" + strconv.Itoa(i)},
+				})
+				if response.Status != shim.OK {
+					b.Fatalf("transferMarble failed: %s", response.Message)
+				}
+			}
+			benchInvoke(b, chaincode, ledger, "getHistoryForMarble", func(i int) []string {
+				return []string{benchPrefix + "0"}
+			})
+		})
+	}
+}
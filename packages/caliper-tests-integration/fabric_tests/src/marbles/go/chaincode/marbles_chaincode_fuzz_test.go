@@ -0,0 +1,188 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Invoke fuzzing ====
+// Arbitrary function names and argument vectors are invoked against a small seeded ledger.
+// Invoke must never panic, must return valid JSON (or nothing) on success, and the color~name
+// index must stay consistent with the marble documents once the transaction is committed.
+//
+// go test -run '^$' -fuzz FuzzInvoke -fuzztime 60s
+
+package chaincode
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-samples/chaincode/marbles02/go/internal/memstub"
+)
+
+// fuzzArgSeparator separates the arguments of a fuzzed argument vector
+const fuzzArgSeparator = "\n"
+
+// fuzzPeerMSPID is the MSP of both the invoker and the endorsing peer
+const fuzzPeerMSPID = "Org1MSP"
+
+// newFuzzLedger returns a ledger holding a few marbles of different colors and owners
+func newFuzzLedger(t *testing.T, chaincode *SimpleChaincode) *memstub.Ledger {
+	ledger := memstub.NewLedger("fuzz")
+	for _, args := range [][]string{
+		{"marble1", "blue", "35", "tom"},
+		{"marble2", "red", "50", "tom"},
+		{"marble3", "blue", "70", "jerry"},
+	} {
+		response := ledger.Invoke(chaincode, memstub.Proposal{Function: "initMarble", Args: args})
+		if response.Status != shim.OK {
+			t.Fatalf("initMarble failed: %s", response.Message)
+		}
+	}
+	return ledger
+}
+
+// checkColorIndex verifies that every marble has exactly one color~name index entry matching
+// its color, and that every index entry refers to an existing marble of that color
+func checkColorIndex(t *testing.T, ledger *memstub.Ledger) {
+	splitter := ledger.NewStub(memstub.Proposal{})
+	marbles := map[string]string{}
+	indexed := map[string]string{}
+
+	ledger.ForEach(func(key string, value []byte) {
+		if !strings.HasPrefix(key, "\x00") {
+			doc := marble{}
+			if err := json.Unmarshal(value, &doc); err != nil || doc.ObjectType != "marble" {
+				return
+			}
+			if doc.Name != key {
+				t.Errorf("marble stored under key %q is named %q", key, doc.Name)
+			}
+			marbles[key] = doc.Color
+			return
+		}
+		objectType, attributes, err := splitter.SplitCompositeKey(key)
+		if err != nil || objectType != "color~name" {
+			return
+		}
+		if len(attributes) != 2 {
+			t.Errorf("color~name index key %q has %d attributes", key, len(attributes))
+			return
+		}
+		if _, exists := indexed[attributes[1]]; exists {
+			t.Errorf("marble %q is indexed under several colors", attributes[1])
+		}
+		indexed[attributes[1]] = attributes[0]
+	})
+
+	for name, color := range marbles {
+		indexedColor, ok := indexed[name]
+		if !ok {
+			t.Errorf("marble %q is missing from the color~name index", name)
+		} else if indexedColor != color {
+			t.Errorf("marble %q is %q but indexed as %q", name, color, indexedColor)
+		}
+	}
+	for name, color := range indexed {
+		if _, ok := marbles[name]; !ok {
+			t.Errorf("color~name index entry %q/%q refers to no marble", color, name)
+		}
+	}
+}
+
+func FuzzInvoke(f *testing.F) {
+	seeds := [][]string{
+		{"initMarble", "marble4", "green", "10", "bob"},
+		{"initMarble", "marble4", "green", "-1", "bob"},
+		{"initMarble", "marble1", "blue", "35", "tom"},
+		{"initMarble", "\x00color~name\x00green\x00marble1\x00", "green", "35", "tom"},
+		{"transferMarble", "marble1", "jerry"},
+		{"transferMarble", "marble9", "jerry"},
+		{"transferMarblesBasedOnColor", "blue", "jerry"},
+		{"delete", "marble2"},
+		{"readMarble", "marble1"},
+		{"readMarbles", `["marble1","marble9"]`},
+		{"getMarblesByRange", "marble1", "marble3"},
+		{"getMarblesByRangeWithPagination", "", "", "2", ""},
+		{"getHistoryForMarble", "marble1"},
+		{"readMarble", "\x00color~name\x00blue\x00marble1\x00"},
+		{"getHistoryForMarble", "\x00color~name\x00blue\x00marble1\x00"},
+		{"queryMarblesByOwner", "tom"},
+		{"searchMarbles", `{"owner":"tom","sortBy":"size"}`},
+		{"seedMarbles", "seed", "3", "42", `{"blue":1}`, ""},
+		{"setQueryPolicy", `{"forceDocType":true,"maxLimit":10}`},
+		{"registerQueryTemplate", "byOwner", `{"selector":{"owner":"${owner}"}}`, `{"owner":"string"}`},
+		{"runNamedQuery", "byOwner", `{"owner":"tom"}`, "", ""},
+		{"agreeToSellMarble", "marble1"},
+		{"sellMarbleWithAgreedPrice", "marble1", "jerry"},
+		{"createBag", "bag1", "tom"},
+		{"addToBag", "bag1", "marble1"},
+		{"removeFromBag", "bag1", "marble1"},
+		{"transferBag", "bag1", "jerry"},
+		{"readBag", "bag1"},
+		{"registerAssetType", `{"name":"car","keyPrefix":"car_","idField":"vin","fields":{"make":"string","owner":"string"},"indexes":[{"name":"make~vin","fields":["make"]}]}`},
+		{"createAsset", "marble", "marble4", `{"color":"green","size":10,"owner":"bob"}`},
+		{"updateAsset", "marble", "marble1", `{"color":"red"}`},
+		{"createAsset", "marble", "marble5", `{"color":"Blue","size":10,"owner":"Bob"}`},
+		{"updateAsset", "marble", "marble1", `{"color":"Red"}`},
+		{"transferAsset", "marble", "marble1", "jerry"},
+		{"deleteAsset", "marble", "marble2"},
+		{"readAsset", "marble", "marble1"},
+		{"queryAssetsByIndex", "marble", "color~name", `["blue"]`, "10", ""},
+		{"proposeTransfer", "marble1", "jerry", "2100-01-01T00:00:00Z"},
+		{"acceptTransfer", "marble1"},
+		{"readTransferOffer", "marble1"},
+		{"lockMarble", "marble1", "2100-01-01T00:00:00Z", "escrow"},
+		{"unlockMarble", "marble1"},
+		{"transferMarblesBasedOnColor", "blue", "jerry", "skip"},
+		{"getProvenance", "marble1"},
+		{"computeStateDigest", "", "", "true"},
+		{"exportMarbles", "", "", "10", ""},
+		{"importMarbles", `{"name":"marble1","color":"green","size":5,"owner":"bob"}`, "overwrite"},
+		{"getMetadata"},
+		{"unknown"},
+	}
+	for _, seed := range seeds {
+		f.Add(seed[0], strings.Join(seed[1:], fuzzArgSeparator))
+	}
+
+	silenceStdout(f)
+
+	// the private sale functions need the invoker and the peer to belong to the same MSP
+	f.Setenv("CORE_PEER_LOCALMSPID", fuzzPeerMSPID)
+	creator, err := memstub.NewIdentity(fuzzPeerMSPID, "fuzzer", []string{roleAdmin}, map[string]string{roleAttribute: roleAdmin})
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Fuzz(func(t *testing.T, function string, joinedArgs string) {
+		args := []string{}
+		if joinedArgs != "" {
+			args = strings.Split(joinedArgs, fuzzArgSeparator)
+		}
+
+		chaincode := new(SimpleChaincode)
+		ledger := newFuzzLedger(t, chaincode)
+		response := ledger.Invoke(chaincode, memstub.Proposal{
+			Function:  function,
+			Args:      args,
+			Creator:   creator,
+			Transient: map[string][]byte{priceTransientKey: []byte(`{"price":100,"tradeId":"trade-1"}`)},
+		})
+
+		if response.Status == shim.OK && len(response.Payload) > 0 && !json.Valid(response.Payload) {
+			t.Errorf("%s%q returned invalid JSON: %q", function, args, response.Payload)
+		}
+		checkColorIndex(t, ledger)
+	})
+}
@@ -0,0 +1,69 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+
+package chaincode
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// readPage reads a page of marbles through getMarblesByRangeWithPagination, returning the
+// names of the marbles and the bookmark of the next page
+func readPage(l *testLedger, pageSize int, bookmark string) ([]string, string) {
+	l.t.Helper()
+	var response []json.RawMessage
+	payload := l.mustInvoke("reader", "getMarblesByRangeWithPagination", "", "", strconv.Itoa(pageSize), bookmark)
+	if err := json.Unmarshal(payload, &response); err != nil {
+		l.t.Fatalf("the response is not a JSON array: %s", err.Error())
+	}
+	if len(response) == 0 {
+		l.t.Fatal("the response has no pagination metadata")
+	}
+	metadata := &paginationMetadataRecord{}
+	if err := json.Unmarshal(response[len(response)-1], metadata); err != nil {
+		l.t.Fatal(err)
+	}
+	names := []string{}
+	for _, recordAsBytes := range response[:len(response)-1] {
+		record := &queryRecord{}
+		if err := json.Unmarshal(recordAsBytes, record); err != nil {
+			l.t.Fatal(err)
+		}
+		names = append(names, record.Key)
+	}
+	if metadata.ResponseMetadata.RecordsCount != strconv.Itoa(len(names)) {
+		l.t.Errorf("got records count %s for %d records", metadata.ResponseMetadata.RecordsCount, len(names))
+	}
+	return names, metadata.ResponseMetadata.Bookmark
+}
+
+func TestGetMarblesByRangeWithPagination(t *testing.T) {
+	l := newTestLedger(t)
+	for _, name := range []string{"marble1", "marble2", "marble3"} {
+		l.mustInvoke("tom", "initMarble", name, "blue", "35", "tom")
+	}
+
+	names, bookmark := readPage(l, 2, "")
+	if !reflect.DeepEqual(names, []string{"marble1", "marble2"}) {
+		t.Errorf("got first page %v, expected marble1 and marble2", names)
+	}
+	names, _ = readPage(l, 2, bookmark)
+	if !reflect.DeepEqual(names, []string{"marble3"}) {
+		t.Errorf("got second page %v, expected marble3", names)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+const (
+	// configObjectType is the composite key object type of chaincode configuration documents.
+	// Config documents live under a composite key so simple key range queries such as
+	// getMarblesByRange skip them.
+	configObjectType = "config"
+
+	// roleAttribute is the certificate attribute that grants chaincode roles to an identity
+	roleAttribute = "marbles.role"
+	roleAdmin     = "admin"
+)
+
+// getConfig reads the configuration document with the given name into v.
+// It returns false if the document has never been set.
+func getConfig(stub shim.ChaincodeStubInterface, name string, v interface{}) (bool, error) {
+	configKey, err := stub.CreateCompositeKey(configObjectType, []string{name})
+	if err != nil {
+		return false, err
+	}
+	configAsBytes, err := stub.GetState(configKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to get %s configuration: %s", name, err.Error())
+	} else if configAsBytes == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(configAsBytes, v); err != nil {
+		return false, fmt.Errorf("failed to decode %s configuration: %s", name, err.Error())
+	}
+	return true, nil
+}
+
+// putConfig stores v as the configuration document with the given name
+func putConfig(stub shim.ChaincodeStubInterface, name string, v interface{}) error {
+	configKey, err := stub.CreateCompositeKey(configObjectType, []string{name})
+	if err != nil {
+		return err
+	}
+	configAsBytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(configKey, configAsBytes)
+}
+
+// assertRole checks that the invoking identity holds the given role, either through the
+// marbles.role certificate attribute or, for the admin role, through the "admin" OU that
+// Fabric assigns to administrator certificates when NodeOUs are enabled.
+func assertRole(stub shim.ChaincodeStubInterface, role string) error {
+	value, found, err := cid.GetAttributeValue(stub, roleAttribute)
+	if err != nil {
+		return fmt.Errorf("failed to identify the invoker: %s", err.Error())
+	}
+	if found && value == role {
+		return nil
+	}
+	if role == roleAdmin {
+		isAdmin, err := cid.HasOUValue(stub, roleAdmin)
+		if err == nil && isAdmin {
+			return nil
+		}
+	}
+	return fmt.Errorf("the invoker does not hold the %s role", role)
+}
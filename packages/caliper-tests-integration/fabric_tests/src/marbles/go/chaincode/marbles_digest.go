@@ -0,0 +1,137 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== State digest ====
+// computeStateDigest hashes the marbles in a key range so that the world state of several peers
+// can be compared without downloading it. The digest is a SHA-256 over the length-prefixed key
+// and value of every marble, in key order. Optionally the color~name index entries of those
+// marbles are hashed after them. Query each peer with the same arguments; equal digests mean
+// equal state. marbles-replay reports this digest over the whole range, index included, for
+// the state a trace replays to.
+//
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["computeStateDigest","marble1","marble9"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["computeStateDigest","","","true"]}'
+
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// stateDigest is the response of computeStateDigest
+type stateDigest struct {
+	StartKey     string `json:"startKey"`
+	EndKey       string `json:"endKey"`
+	IncludeIndex bool   `json:"includeIndex"`
+	Digest       string `json:"digest"` // hex SHA-256
+	Marbles      int    `json:"marbles"`
+	IndexEntries int    `json:"indexEntries"`
+}
+
+// writeDigestRecord adds a length-prefixed key and value to a digest
+func writeDigestRecord(digest hash.Hash, key string, value []byte) {
+	length := make([]byte, 8)
+	binary.BigEndian.PutUint64(length, uint64(len(key)))
+	digest.Write(length)
+	digest.Write([]byte(key))
+	binary.BigEndian.PutUint64(length, uint64(len(value)))
+	digest.Write(length)
+	digest.Write(value)
+}
+
+// inKeyRange reports whether a key falls in [startKey, endKey), where an empty endKey is unbounded
+func inKeyRange(key, startKey, endKey string) bool {
+	return key >= startKey && (endKey == "" || key < endKey)
+}
+
+// ===========================================================================================
+// computeStateDigest - hash the marbles in a key range, and optionally their index entries
+// ===========================================================================================
+func (t *SimpleChaincode) computeStateDigest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//     0          1          2
+	// "marble1", "marble9", "true"
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	result := &stateDigest{StartKey: args[0], EndKey: args[1]}
+	if len(args) == 3 && len(args[2]) > 0 {
+		includeIndex, err := strconv.ParseBool(args[2])
+		if err != nil {
+			return shim.Error("3rd argument must be true or false")
+		}
+		result.IncludeIndex = includeIndex
+	}
+	fmt.Printf("- start computeStateDigest: %s %s %t\n", result.StartKey, result.EndKey, result.IncludeIndex)
+
+	digest := sha256.New()
+	resultsIterator, err := stub.GetStateByRange(result.StartKey, result.EndKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !isMarbleDocument(queryResponse.Value) {
+			continue
+		}
+		writeDigestRecord(digest, queryResponse.Key, queryResponse.Value)
+		result.Marbles++
+	}
+
+	if result.IncludeIndex {
+		// Index entries are ordered by color, so the whole index is scanned and the entries
+		// are filtered on the marble name
+		indexIterator, err := stub.GetStateByPartialCompositeKey("color~name", []string{})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		defer indexIterator.Close()
+		for indexIterator.HasNext() {
+			queryResponse, err := indexIterator.Next()
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			_, compositeKeyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if len(compositeKeyParts) != 2 || !inKeyRange(compositeKeyParts[1], result.StartKey, result.EndKey) {
+				continue
+			}
+			writeDigestRecord(digest, queryResponse.Key, queryResponse.Value)
+			result.IndexEntries++
+		}
+	}
+
+	result.Digest = hex.EncodeToString(digest.Sum(nil))
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- computeStateDigest returning %s over %d marbles\n", result.Digest, result.Marbles)
+	return shim.Success(resultAsBytes)
+}
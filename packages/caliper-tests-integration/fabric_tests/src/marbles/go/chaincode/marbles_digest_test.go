@@ -0,0 +1,60 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+
+
+package chaincode
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// digestOf imports marble documents in a single transaction and returns the state digest over
+// the whole range, index included
+func digestOf(t *testing.T, records ...string) *stateDigest {
+	l := newTestLedger(t)
+	l.mustInvoke(roleAdmin, "importMarbles", strings.Join(records, "\n"))
+	result := &stateDigest{}
+	if err := json.Unmarshal(l.mustInvoke("reader", "computeStateDigest", "", "", "true"), result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestStateDigestInsertionOrder(t *testing.T) {
+	marble1 := `{"name":"marble1","color":"blue","size":35,"owner":"tom"}`
+	marble2 := `{"name":"marble2","color":"red","size":50,"owner":"tom"}`
+	marble3 := `{"name":"marble3","color":"blue","size":70,"owner":"jerry"}`
+	want := digestOf(t, marble1, marble2, marble3)
+	if want.Marbles != 3 || want.IndexEntries != 3 {
+		t.Fatalf("digest covers %d marbles and %d index entries, want 3 and 3", want.Marbles, want.IndexEntries)
+	}
+
+	for _, order := range [][]string{
+		{marble3, marble2, marble1},
+		{marble2, marble3, marble1},
+		{marble1, marble3, marble2},
+	} {
+		if got := digestOf(t, order...); got.Digest != want.Digest {
+			t.Errorf("digest %s of marbles inserted in another order, want %s", got.Digest, want.Digest)
+		}
+	}
+
+	resized := `{"name":"marble2","color":"red","size":51,"owner":"tom"}`
+	if got := digestOf(t, marble1, resized, marble3); got.Digest == want.Digest {
+		t.Error("digest did not change with the size of a marble")
+	}
+}
@@ -0,0 +1,301 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Dry run ====
+// Any function can be invoked with dryRun in the transient map. Writes are recorded instead of
+// being applied, and the response is the trace of every state access the function made.
+//
+// export DRY_RUN=$(echo -n "true" | base64 | tr -d \\n)
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["transferMarblesBasedOnColor","blue","jerry"]}' --transient "{\"dryRun\":\"$DRY_RUN\"}"
+
+package chaincode
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// dryRunTransientKey is the transient map entry enabling a dry run
+const dryRunTransientKey = "dryRun"
+
+// stateAccess is a single state operation issued during a dry run
+type stateAccess struct {
+	Operation  string   `json:"op"`
+	Collection string   `json:"collection,omitempty"`
+	Key        string   `json:"key,omitempty"`
+	StartKey   string   `json:"startKey,omitempty"`
+	EndKey     string   `json:"endKey,omitempty"`
+	Query      string   `json:"query,omitempty"`
+	ValueSize  *int     `json:"valueSize,omitempty"` // size of the value read or written, absent if none
+	Found      *bool    `json:"found,omitempty"`     // for point reads
+	Results    []string `json:"results,omitempty"`   // keys returned by range, query and history reads
+}
+
+// dryRunWrite is a key of the would-be write set
+type dryRunWrite struct {
+	Collection string `json:"collection,omitempty"`
+	Key        string `json:"key"`
+	IsDelete   bool   `json:"isDelete"`
+	ValueSize  int    `json:"valueSize"`
+}
+
+// dryRunResult is the response of a dry run
+type dryRunResult struct {
+	Function string          `json:"function"`
+	Status   int32           `json:"status"`
+	Message  string          `json:"message,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"` // response of the function, if it is JSON
+	Accesses []*stateAccess  `json:"accesses"`
+	ReadSet  []string        `json:"readSet"`  // keys subject to MVCC validation at commit, sorted
+	WriteSet []dryRunWrite   `json:"writeSet"` // last write of each key, sorted by collection and key
+}
+
+// isDryRun reports whether the transient map requests a dry run
+func isDryRun(stub shim.ChaincodeStubInterface) (bool, error) {
+	transientMap, err := stub.GetTransient()
+	if err != nil {
+		return false, err
+	}
+	value, ok := transientMap[dryRunTransientKey]
+	if !ok {
+		return false, nil
+	}
+	return strconv.ParseBool(string(value))
+}
+
+// dryRunStub records the state operations of a single invocation. Reads are passed through to
+// the wrapped stub; writes are recorded and dropped.
+type dryRunStub struct {
+	shim.ChaincodeStubInterface
+	accesses []*stateAccess
+}
+
+func newDryRunStub(stub shim.ChaincodeStubInterface) *dryRunStub {
+	return &dryRunStub{ChaincodeStubInterface: stub, accesses: []*stateAccess{}}
+}
+
+// record appends an access to the trace
+func (s *dryRunStub) record(access *stateAccess) *stateAccess {
+	s.accesses = append(s.accesses, access)
+	return access
+}
+
+// pointRead records a read of a single key
+func (s *dryRunStub) pointRead(operation, collection, key string, value []byte) {
+	found := value != nil
+	access := &stateAccess{Operation: operation, Collection: collection, Key: key, Found: &found}
+	if found {
+		size := len(value)
+		access.ValueSize = &size
+	}
+	s.record(access)
+}
+
+// write records a write or delete
+func (s *dryRunStub) write(operation, collection, key string, value []byte) {
+	access := &stateAccess{Operation: operation, Collection: collection, Key: key}
+	if operation == opPutState || operation == opPutPrivateData {
+		size := len(value)
+		access.ValueSize = &size
+	}
+	s.record(access)
+}
+
+func (s *dryRunStub) GetState(key string) ([]byte, error) {
+	value, err := s.ChaincodeStubInterface.GetState(key)
+	if err == nil {
+		s.pointRead(opGetState, "", key, value)
+	}
+	return value, err
+}
+
+func (s *dryRunStub) PutState(key string, value []byte) error {
+	s.write(opPutState, "", key, value)
+	return nil
+}
+
+func (s *dryRunStub) DelState(key string) error {
+	s.write(opDelState, "", key, nil)
+	return nil
+}
+
+func (s *dryRunStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	iterator, err := s.ChaincodeStubInterface.GetStateByRange(startKey, endKey)
+	access := s.record(&stateAccess{Operation: opGetStateByRange, StartKey: startKey, EndKey: endKey})
+	return wrapDryRunIterator(iterator, access), err
+}
+
+func (s *dryRunStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	iterator, metadata, err := s.ChaincodeStubInterface.GetStateByRangeWithPagination(startKey, endKey, pageSize, bookmark)
+	access := s.record(&stateAccess{Operation: opGetStateByRange, StartKey: startKey, EndKey: endKey})
+	return wrapDryRunIterator(iterator, access), metadata, err
+}
+
+func (s *dryRunStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	iterator, err := s.ChaincodeStubInterface.GetStateByPartialCompositeKey(objectType, keys)
+	access := s.record(&stateAccess{Operation: opGetStateByPartialCompositeKey, StartKey: partialKey(s, objectType, keys)})
+	return wrapDryRunIterator(iterator, access), err
+}
+
+func (s *dryRunStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	iterator, metadata, err := s.ChaincodeStubInterface.GetStateByPartialCompositeKeyWithPagination(objectType, keys, pageSize, bookmark)
+	access := s.record(&stateAccess{Operation: opGetStateByPartialCompositeKey, StartKey: partialKey(s, objectType, keys)})
+	return wrapDryRunIterator(iterator, access), metadata, err
+}
+
+func (s *dryRunStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	iterator, err := s.ChaincodeStubInterface.GetQueryResult(query)
+	access := s.record(&stateAccess{Operation: opGetQueryResult, Query: query})
+	return wrapDryRunIterator(iterator, access), err
+}
+
+func (s *dryRunStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	iterator, metadata, err := s.ChaincodeStubInterface.GetQueryResultWithPagination(query, pageSize, bookmark)
+	access := s.record(&stateAccess{Operation: opGetQueryResult, Query: query})
+	return wrapDryRunIterator(iterator, access), metadata, err
+}
+
+func (s *dryRunStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	iterator, err := s.ChaincodeStubInterface.GetHistoryForKey(key)
+	access := s.record(&stateAccess{Operation: opGetHistoryForKey, Key: key})
+	if iterator == nil {
+		return nil, err
+	}
+	return &dryRunHistoryIterator{iterator, access}, err
+}
+
+func (s *dryRunStub) GetPrivateData(collection, key string) ([]byte, error) {
+	value, err := s.ChaincodeStubInterface.GetPrivateData(collection, key)
+	if err == nil {
+		s.pointRead(opGetPrivateData, collection, key, value)
+	}
+	return value, err
+}
+
+func (s *dryRunStub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	hash, err := s.ChaincodeStubInterface.GetPrivateDataHash(collection, key)
+	if err == nil {
+		s.pointRead(opGetPrivateDataHash, collection, key, hash)
+	}
+	return hash, err
+}
+
+func (s *dryRunStub) PutPrivateData(collection, key string, value []byte) error {
+	s.write(opPutPrivateData, collection, key, value)
+	return nil
+}
+
+func (s *dryRunStub) DelPrivateData(collection, key string) error {
+	s.write(opDelPrivateData, collection, key, nil)
+	return nil
+}
+
+// partialKey returns the start of the key range of a partial composite key query
+func partialKey(stub shim.ChaincodeStubInterface, objectType string, keys []string) string {
+	key, err := stub.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return objectType
+	}
+	return key
+}
+
+func wrapDryRunIterator(iterator shim.StateQueryIteratorInterface, access *stateAccess) shim.StateQueryIteratorInterface {
+	if iterator == nil {
+		return nil
+	}
+	return &dryRunStateIterator{iterator, access}
+}
+
+// dryRunStateIterator records the keys read from a state query iterator
+type dryRunStateIterator struct {
+	shim.StateQueryIteratorInterface
+	access *stateAccess
+}
+
+func (i *dryRunStateIterator) Next() (*queryresult.KV, error) {
+	kv, err := i.StateQueryIteratorInterface.Next()
+	if err == nil {
+		i.access.Results = append(i.access.Results, kv.Key)
+	}
+	return kv, err
+}
+
+// dryRunHistoryIterator records the transactions read from a history query iterator
+type dryRunHistoryIterator struct {
+	shim.HistoryQueryIteratorInterface
+	access *stateAccess
+}
+
+func (i *dryRunHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	modification, err := i.HistoryQueryIteratorInterface.Next()
+	if err == nil {
+		i.access.Results = append(i.access.Results, modification.TxId)
+	}
+	return modification, err
+}
+
+// result builds the response of a dry run from the function's response and the recorded trace
+func (s *dryRunStub) result(function string, response pb.Response) ([]byte, error) {
+	result := &dryRunResult{
+		Function: function,
+		Status:   response.Status,
+		Message:  response.Message,
+		Accesses: s.accesses,
+		ReadSet:  []string{},
+		WriteSet: []dryRunWrite{},
+	}
+	if len(response.Payload) > 0 && json.Valid(response.Payload) {
+		result.Payload = response.Payload
+	}
+
+	reads := map[string]bool{}
+	writes := map[[2]string]dryRunWrite{}
+	for _, access := range s.accesses {
+		switch access.Operation {
+		case opGetState:
+			reads[access.Key] = true
+		case opGetStateByRange, opGetStateByPartialCompositeKey:
+			for _, key := range access.Results {
+				reads[key] = true
+			}
+		case opPutState, opDelState, opPutPrivateData, opDelPrivateData:
+			write := dryRunWrite{Collection: access.Collection, Key: access.Key}
+			if access.ValueSize != nil {
+				write.ValueSize = *access.ValueSize
+			} else {
+				write.IsDelete = true
+			}
+			writes[[2]string{access.Collection, access.Key}] = write
+		}
+	}
+	for key := range reads {
+		result.ReadSet = append(result.ReadSet, key)
+	}
+	sort.Strings(result.ReadSet)
+	for _, write := range writes {
+		result.WriteSet = append(result.WriteSet, write)
+	}
+	sort.Slice(result.WriteSet, func(i, j int) bool {
+		if result.WriteSet[i].Collection != result.WriteSet[j].Collection {
+			return result.WriteSet[i].Collection < result.WriteSet[j].Collection
+		}
+		return result.WriteSet[i].Key < result.WriteSet[j].Key
+	})
+	return json.Marshal(result)
+}
@@ -0,0 +1,297 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Export and import ====
+// exportMarbles returns a page of the marbles in a key range as newline-delimited JSON, one
+// marble document per line, together with the bookmark of the next page. importMarbles takes
+// such a document and recreates the marbles and their color~name index entries, so that a
+// dataset can be carried between channels and networks. Only name, color, size and owner are
+// imported: timestamps and lastTxId are those of the import transaction, and bag membership and
+// locks are not carried over. Marbles that already exist are skipped, or replaced with the
+// "overwrite" policy. Sizes must not be negative, and names may not fall under the key prefix
+// of a registered asset type. Importing is restricted to admins.
+//
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["exportMarbles","","","100",""]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["importMarbles","{\"name\":\"marble1\",\"color\":\"blue\",\"size\":35,\"owner\":\"tom\"}\n","skip"]}'
+
+package chaincode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	// maxImportMarbles bounds the number of marbles a single importMarbles transaction may
+	// write, for the same reason as maxSeedCount
+	maxImportMarbles = maxSeedCount
+
+	importSkip      = "skip"      // leave existing marbles untouched (default)
+	importOverwrite = "overwrite" // replace existing marbles
+)
+
+// exportPage is the response of exportMarbles
+type exportPage struct {
+	NDJSON              string `json:"ndjson"`
+	Exported            int    `json:"exported"`
+	FetchedRecordsCount int32  `json:"fetchedRecordsCount"` // includes keys that are not marbles
+	Bookmark            string `json:"bookmark"`
+}
+
+// importResult is the response of importMarbles
+type importResult struct {
+	Created     int      `json:"created"`
+	Overwritten int      `json:"overwritten"`
+	Skipped     []string `json:"skipped"`
+}
+
+// parseImportedMarbles decodes newline-delimited marble documents, ignoring blank lines
+func parseImportedMarbles(ndjson string) ([]*marble, error) {
+	var marbles []*marble
+	names := map[string]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(ndjson))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(ndjson)+1)
+	for line := 1; scanner.Scan(); line++ {
+		record := bytes.TrimSpace(scanner.Bytes())
+		if len(record) == 0 {
+			continue
+		}
+		marbleDoc := &marble{}
+		decoder := json.NewDecoder(bytes.NewReader(record))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(marbleDoc); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		if marbleDoc.ObjectType != "" && marbleDoc.ObjectType != "marble" {
+			return nil, fmt.Errorf("line %d: docType must be marble", line)
+		}
+		if len(marbleDoc.Name) <= 0 || len(marbleDoc.Color) <= 0 || len(marbleDoc.Owner) <= 0 {
+			return nil, fmt.Errorf("line %d: name, color and owner must be non-empty strings", line)
+		}
+		if marbleDoc.Size < 0 {
+			return nil, fmt.Errorf("line %d: size must not be negative", line)
+		}
+		// owners, config and indexes live under composite keys, which start with U+0000
+		if !utf8.ValidString(marbleDoc.Name) || marbleDoc.Name[0] == 0 {
+			return nil, fmt.Errorf("line %d: name must be valid UTF-8 and not start with U+0000", line)
+		}
+		if names[marbleDoc.Name] {
+			return nil, fmt.Errorf("line %d: marble %s appears more than once", line, marbleDoc.Name)
+		}
+		names[marbleDoc.Name] = true
+		if len(marbles) >= maxImportMarbles {
+			return nil, fmt.Errorf("at most %d marbles may be imported at once", maxImportMarbles)
+		}
+		marbles = append(marbles, &marble{
+			ObjectType: "marble",
+			Name:       marbleDoc.Name,
+			Color:      strings.ToLower(marbleDoc.Color),
+			Size:       marbleDoc.Size,
+			Owner:      strings.ToLower(marbleDoc.Owner),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return marbles, nil
+}
+
+// checkImportedKeys rejects imported marbles whose name falls under the key prefix of a
+// registered asset type
+func checkImportedKeys(stub shim.ChaincodeStubInterface, marbles []*marble) error {
+	types, err := listAssetTypeDocs(stub)
+	if err != nil {
+		return err
+	}
+	for _, a := range types {
+		if a.KeyPrefix == "" {
+			continue
+		}
+		for _, marbleDoc := range marbles {
+			if strings.HasPrefix(marbleDoc.Name, a.KeyPrefix) {
+				return fmt.Errorf("marble %s falls under the key prefix %q of asset type %s", marbleDoc.Name, a.KeyPrefix, a.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// putColorIndex writes the color~name index entry of a marble
+func putColorIndex(stub shim.ChaincodeStubInterface, marbleDoc *marble) error {
+	colorNameIndexKey, err := stub.CreateCompositeKey("color~name", []string{marbleDoc.Color, marbleDoc.Name})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(colorNameIndexKey, []byte{0x00})
+}
+
+// ===========================================================================================
+// exportMarbles - export a page of the marbles in a key range as newline-delimited JSON
+// ===========================================================================================
+func (t *SimpleChaincode) exportMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1       2      3
+	// "",      "",   "100",   ""
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	startKey := args[0]
+	endKey := args[1]
+	pageSize, err := strconv.ParseInt(args[2], 10, 32)
+	if err != nil || pageSize <= 0 || pageSize > maxReadMarblesCount {
+		return shim.Error(fmt.Sprintf("3rd argument must be a page size between 1 and %d", maxReadMarblesCount))
+	}
+	bookmark := args[3]
+	fmt.Printf("- start exportMarbles: %s %s %d\n", startKey, endKey, pageSize)
+
+	resultsIterator, responseMetadata, err := stub.GetStateByRangeWithPagination(startKey, endKey, int32(pageSize), bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var buffer bytes.Buffer
+	page := &exportPage{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !isMarbleDocument(queryResponse.Value) {
+			continue
+		}
+		var record bytes.Buffer
+		if err := json.Compact(&record, queryResponse.Value); err != nil {
+			return shim.Error(err.Error())
+		}
+		buffer.Write(record.Bytes())
+		buffer.WriteByte('\n')
+		page.Exported++
+	}
+	page.NDJSON = buffer.String()
+	page.FetchedRecordsCount = responseMetadata.FetchedRecordsCount
+	page.Bookmark = responseMetadata.Bookmark
+
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- exportMarbles returning %d marbles\n", page.Exported)
+	return shim.Success(pageAsBytes)
+}
+
+// ===========================================================================================
+// importMarbles - create the marbles of a newline-delimited JSON export, with their indexes.
+// Existing marbles are skipped, or replaced with the overwrite policy; marbles in a bag or
+// under an active lock cannot be replaced.
+// ===========================================================================================
+func (t *SimpleChaincode) importMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//       0              1
+	// "{...}\n{...}\n", "skip"
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+	}
+	policy := importSkip
+	if len(args) == 2 && len(args[1]) > 0 {
+		policy = args[1]
+	}
+	if policy != importSkip && policy != importOverwrite {
+		return shim.Error("2nd argument must be " + importSkip + " or " + importOverwrite)
+	}
+	marbles, err := parseImportedMarbles(args[0])
+	if err != nil {
+		return shim.Error("Invalid import: " + err.Error())
+	}
+	if err := checkImportedKeys(stub, marbles); err != nil {
+		return shim.Error("Invalid import: " + err.Error())
+	}
+	fmt.Printf("- start importMarbles: %d marbles, policy %s\n", len(marbles), policy)
+
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error("Failed to get transaction timestamp: " + err.Error())
+	}
+	result := &importResult{Skipped: []string{}}
+	holdingDeltas := map[string]int{}
+	for _, marbleDoc := range marbles {
+		existing, err := stub.GetState(marbleDoc.Name)
+		if err != nil {
+			return shim.Error("Failed to get marble: " + err.Error())
+		}
+		marbleDoc.CreatedAt = timestamp
+		if existing != nil {
+			if policy == importSkip {
+				result.Skipped = append(result.Skipped, marbleDoc.Name)
+				continue
+			}
+			previous := &marble{}
+			if err := json.Unmarshal(existing, previous); err != nil {
+				return shim.Error(err.Error())
+			}
+			if previous.ObjectType != "marble" {
+				return shim.Error("Key " + marbleDoc.Name + " holds a " + previous.ObjectType + ", not a marble")
+			}
+			if previous.Bag != "" {
+				return shim.Error("Marble " + marbleDoc.Name + " is in bag " + previous.Bag + "; remove it from the bag first")
+			}
+			if err := checkUnlocked(stub, marbleDoc.Name, previous.Lock); err != nil {
+				return shim.Error(err.Error())
+			}
+			if previous.Color != marbleDoc.Color {
+				colorNameIndexKey, err := stub.CreateCompositeKey("color~name", []string{previous.Color, previous.Name})
+				if err != nil {
+					return shim.Error(err.Error())
+				}
+				if err := stub.DelState(colorNameIndexKey); err != nil {
+					return shim.Error("Failed to delete state:" + err.Error())
+				}
+			}
+			holdingDeltas[previous.Owner]--
+			marbleDoc.CreatedAt = previous.CreatedAt
+			result.Overwritten++
+		} else {
+			result.Created++
+		}
+		if err := assertOwnerRegistered(stub, marbleDoc.Owner); err != nil {
+			return shim.Error(err.Error())
+		}
+		holdingDeltas[marbleDoc.Owner]++
+
+		if err := putMarble(stub, marbleDoc); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := putColorIndex(stub, marbleDoc); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if err := adjustHoldings(stub, holdingDeltas); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Println("- end importMarbles")
+	return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,94 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+
+
+package chaincode
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestImportValidation(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		ndjson string
+		want   string
+	}{
+		{name: "negative size", ndjson: `{"name":"marble9","color":"blue","size":-1,"owner":"tom"}`, want: "line 1: size must not be negative"},
+		{name: "empty owner", ndjson: `{"name":"marble9","color":"blue","size":1,"owner":""}`, want: "line 1: name, color and owner must be non-empty"},
+		{name: "composite namespace", ndjson: "{\"name\":\"\\u0000owner\\u0000tom\\u0000\",\"color\":\"blue\",\"size\":1,\"owner\":\"tom\"}", want: "not start with U+0000"},
+		{name: "asset key prefix", ndjson: `{"name":"car_vin2","color":"blue","size":1,"owner":"tom"}`, want: `falls under the key prefix "car_" of asset type car`},
+		{name: "duplicate", ndjson: "{\"name\":\"marble9\",\"color\":\"blue\",\"size\":1,\"owner\":\"tom\"}\n{\"name\":\"marble9\",\"color\":\"red\",\"size\":1,\"owner\":\"tom\"}", want: "line 2: marble marble9 appears more than once"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := newAssetLedger(t)
+			l.mustFail(test.want, roleAdmin, "importMarbles", test.ndjson)
+		})
+	}
+}
+
+func TestExportPageSize(t *testing.T) {
+	l := newTestLedger(t)
+	for _, pageSize := range []string{"0", "-1", "1001", "ten"} {
+		l.mustFail("3rd argument must be a page size between 1 and 1000", "reader", "exportMarbles", "", "", pageSize, "")
+	}
+	l.mustInvoke("reader", "exportMarbles", "", "", "1000", "")
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	source := newTestLedger(t)
+	source.mustInvoke("tom", "registerOwner", "tom", "Tom")
+	source.mustInvoke("tom", "initMarble", "marble1", "blue", "35", "tom")
+	source.mustInvoke("tom", "initMarble", "marble2", "red", "50", "tom")
+	page := &exportPage{}
+	if err := json.Unmarshal(source.mustInvoke("reader", "exportMarbles", "", "", "100", ""), page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Exported != 2 {
+		t.Fatalf("exported %d marbles, want 2", page.Exported)
+	}
+
+	for _, test := range []struct {
+		policy     string
+		want       importResult
+		wantMarble marble // marble1 after the import
+	}{
+		{policy: importSkip, want: importResult{Created: 1, Skipped: []string{"marble1"}}, wantMarble: marble{Color: "green", Size: 10}},
+		{policy: importOverwrite, want: importResult{Created: 1, Overwritten: 1, Skipped: []string{}}, wantMarble: marble{Color: "blue", Size: 35}},
+	} {
+		t.Run(test.policy, func(t *testing.T) {
+			l := newTestLedger(t)
+			l.mustInvoke("tom", "registerOwner", "tom", "Tom")
+			l.mustInvoke("tom", "initMarble", "marble1", "green", "10", "tom")
+
+			result := &importResult{}
+			if err := json.Unmarshal(l.mustInvoke(roleAdmin, "importMarbles", page.NDJSON, test.policy), result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Created != test.want.Created || result.Overwritten != test.want.Overwritten || len(result.Skipped) != len(test.want.Skipped) {
+				t.Fatalf("import returned %+v, want %+v", result, test.want)
+			}
+			if marbleDoc := readTestMarble(l, "marble1"); marbleDoc.Color != test.wantMarble.Color || marbleDoc.Size != test.wantMarble.Size {
+				t.Errorf("marble1 is %s/%d, want %s/%d", marbleDoc.Color, marbleDoc.Size, test.wantMarble.Color, test.wantMarble.Size)
+			}
+			if marbleDoc := readTestMarble(l, "marble2"); marbleDoc.Color != "red" || marbleDoc.Size != 50 || marbleDoc.Owner != "tom" {
+				t.Errorf("marble2 is %s/%d of %s, want red/50 of tom", marbleDoc.Color, marbleDoc.Size, marbleDoc.Owner)
+			}
+
+			checkColorIndex(t, l.ledger)
+		})
+	}
+}
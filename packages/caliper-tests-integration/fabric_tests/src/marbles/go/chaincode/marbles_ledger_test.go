@@ -0,0 +1,107 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Behavior test helpers ====
+// Behavior tests invoke the chaincode against an in-memory ledger, committing every successful
+// transaction in its own block, as named identities of a single organization.
+//
+// go test -run Test
+
+package chaincode
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric-samples/chaincode/marbles02/go/internal/memstub"
+)
+
+// testMSPID is the MSP of every test identity and of the endorsing peer
+const testMSPID = "Org1MSP"
+
+// testLedger is a ledger together with the identities invoking it
+type testLedger struct {
+	t          *testing.T
+	chaincode  *SimpleChaincode
+	ledger     *memstub.Ledger
+	identities map[string][]byte
+
+	// now is the timestamp of the following transactions; zero lets the ledger derive one
+	// from its height
+	now time.Time
+}
+
+func newTestLedger(t *testing.T) *testLedger {
+	silenceStdout(t)
+	t.Setenv("CORE_PEER_LOCALMSPID", testMSPID)
+	return &testLedger{
+		t:          t,
+		chaincode:  new(SimpleChaincode),
+		ledger:     memstub.NewLedger("test"),
+		identities: map[string][]byte{},
+	}
+}
+
+// identity returns the serialized identity with the given common name. The identity named
+// admin holds the admin role.
+func (l *testLedger) identity(name string) []byte {
+	creator, ok := l.identities[name]
+	if !ok {
+		var organizationalUnits []string
+		if name == roleAdmin {
+			organizationalUnits = []string{roleAdmin}
+		}
+		var err error
+		creator, err = memstub.NewIdentity(testMSPID, name, organizationalUnits, nil)
+		if err != nil {
+			l.t.Fatal(err)
+		}
+		l.identities[name] = creator
+	}
+	return creator
+}
+
+// invoke runs a function as the named identity
+func (l *testLedger) invoke(as, function string, args ...string) pb.Response {
+	return l.ledger.Invoke(l.chaincode, memstub.Proposal{
+		Function:  function,
+		Args:      args,
+		Creator:   l.identity(as),
+		Timestamp: l.now,
+	})
+}
+
+// mustInvoke runs a function that must succeed, and returns its payload
+func (l *testLedger) mustInvoke(as, function string, args ...string) []byte {
+	l.t.Helper()
+	response := l.invoke(as, function, args...)
+	if response.Status != shim.OK {
+		l.t.Fatalf("%s(%s) as %s failed: %s", function, strings.Join(args, ", "), as, response.Message)
+	}
+	return response.Payload
+}
+
+// mustFail runs a function that must fail with a message containing want
+func (l *testLedger) mustFail(want, as, function string, args ...string) {
+	l.t.Helper()
+	response := l.invoke(as, function, args...)
+	if response.Status == shim.OK {
+		l.t.Fatalf("%s(%s) as %s succeeded, expected %q", function, strings.Join(args, ", "), as, want)
+	} else if !strings.Contains(response.Message, want) {
+		l.t.Fatalf("%s(%s) as %s failed with %q, expected %q", function, strings.Join(args, ", "), as, response.Message, want)
+	}
+}
@@ -0,0 +1,196 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Time-locked marbles ====
+// A locked marble cannot be transferred or deleted until the lock expires, evaluated against
+// the transaction timestamp, and at most maxLockDays after the lock is set. The lock is stored
+// on the marble, so readMarble shows it. Only the identity that locked a marble, or an admin,
+// may unlock it early, unless the owner is unregistered: anyone may act for such an owner, so
+// anyone may lock its marbles and anyone may unlock them.
+// transferMarblesBasedOnColor rejects locked marbles, or skips them with the "skip" mode.
+//
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["lockMarble","marble1","2030-01-01T00:00:00Z","escrow"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["unlockMarble","marble1"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["transferMarblesBasedOnColor","blue","jerry","skip"]}'
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Modes of transferMarblesBasedOnColor for locked marbles
+const (
+	colorTransferReject = "reject" // fail the transaction (default)
+	colorTransferSkip   = "skip"   // leave locked marbles with their owner
+)
+
+// maxLockDays bounds how long after the transaction timestamp a lock may expire
+const maxLockDays = 366
+
+// marbleLock prevents a marble from being transferred or deleted until a given time
+type marbleLock struct {
+	Until    string `json:"until"` // RFC 3339
+	Reason   string `json:"reason"`
+	LockedBy string `json:"lockedBy"` // unique ID of the locking identity
+	LockTxID string `json:"lockTxId"`
+}
+
+// active reports whether the lock still holds at the given time. lockMarble only writes
+// expiries that parse, so one that does not is not taken as a lock that never expires.
+func (l *marbleLock) active(now time.Time) bool {
+	if l == nil {
+		return false
+	}
+	until, err := time.Parse(time.RFC3339Nano, l.Until)
+	return err == nil && now.Before(until)
+}
+
+// checkUnlocked fails if a lock is active at the transaction timestamp
+func checkUnlocked(stub shim.ChaincodeStubInterface, name string, lock *marbleLock) error {
+	if lock == nil {
+		return nil
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	if lock.active(now) {
+		return fmt.Errorf("marble %s is locked until %s: %s", name, lock.Until, lock.Reason)
+	}
+	return nil
+}
+
+// assetLock returns the lock of an asset document, if any
+func assetLock(asset map[string]interface{}) *marbleLock {
+	value, ok := asset["lock"]
+	if !ok || value == nil {
+		return nil
+	}
+	lockAsBytes, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	lock := &marbleLock{}
+	if err := json.Unmarshal(lockAsBytes, lock); err != nil {
+		return nil
+	}
+	return lock
+}
+
+// ==========================================================================================
+// lockMarble - lock a marble until the given time. A marble holds a single lock; an expired
+// lock is replaced, an active one must be unlocked first.
+// ==========================================================================================
+func (t *SimpleChaincode) lockMarble(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//     0                 1                  2
+	// "marble1", "2030-01-01T00:00:00Z", "escrow"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	marbleName := args[0]
+	until, err := time.Parse(time.RFC3339Nano, args[1])
+	if err != nil {
+		return shim.Error("2nd argument must be an RFC 3339 timestamp")
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !now.Before(until) {
+		return shim.Error("2nd argument must be after the transaction timestamp")
+	}
+	if until.After(now.AddDate(0, 0, maxLockDays)) {
+		return shim.Error(fmt.Sprintf("2nd argument must be at most %d days after the transaction timestamp", maxLockDays))
+	}
+
+	marbleDoc, err := getMarble(stub, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if marbleDoc.Lock.active(now) {
+		return shim.Error("Marble " + marbleName + " is already locked until " + marbleDoc.Lock.Until)
+	}
+	if err := assertActsFor(stub, marbleDoc.Owner); err != nil {
+		return shim.Error(err.Error())
+	}
+	lockedBy, err := cid.GetID(stub)
+	if err != nil {
+		return shim.Error("Failed to identify the invoker: " + err.Error())
+	}
+
+	marbleDoc.Lock = &marbleLock{
+		Until:    until.UTC().Format(time.RFC3339Nano),
+		Reason:   args[2],
+		LockedBy: lockedBy,
+		LockTxID: stub.GetTxID(),
+	}
+	if err := putMarble(stub, marbleDoc); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ==========================================================================================
+// unlockMarble - remove the lock of a marble. An active lock can only be removed by the
+// identity that set it, or by an admin, unless the marble's owner is unregistered.
+// ==========================================================================================
+func (t *SimpleChaincode) unlockMarble(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	marbleName := args[0]
+
+	marbleDoc, err := getMarble(stub, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if marbleDoc.Lock == nil {
+		return shim.Error("Marble " + marbleName + " is not locked")
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if marbleDoc.Lock.active(now) {
+		invoker, err := cid.GetID(stub)
+		if err != nil {
+			return shim.Error("Failed to identify the invoker: " + err.Error())
+		}
+		if invoker != marbleDoc.Lock.LockedBy {
+			ownerDoc, err := getOwner(stub, marbleDoc.Owner)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if ownerDoc != nil {
+				if err := assertRole(stub, roleAdmin); err != nil {
+					return shim.Error(fmt.Sprintf("Only the identity that locked marble %s or an admin may unlock it before %s", marbleName, marbleDoc.Lock.Until))
+				}
+			}
+		}
+	}
+
+	marbleDoc.Lock = nil
+	if err := putMarble(stub, marbleDoc); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
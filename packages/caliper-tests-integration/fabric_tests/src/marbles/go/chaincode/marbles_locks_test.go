@@ -0,0 +1,111 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+
+package chaincode
+
+import (
+	"testing"
+	"time"
+)
+
+// lockTestTime is the timestamp of the transactions setting up the lock tests
+var lockTestTime = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newLockLedger returns a ledger holding marble1 of the registered owner tom and marble2 of the
+// unregistered owner spike, both blue
+func newLockLedger(t *testing.T) *testLedger {
+	l := newTestLedger(t)
+	l.now = lockTestTime
+	l.mustInvoke("tom", "registerOwner", "tom", "Tom")
+	l.mustInvoke("tom", "initMarble", "marble1", "blue", "35", "tom")
+	l.mustInvoke("spike", "initMarble", "marble2", "blue", "50", "spike")
+	return l
+}
+
+func TestLockExpiry(t *testing.T) {
+	l := newLockLedger(t)
+	until := lockTestTime.Add(time.Hour).Format(time.RFC3339)
+	l.mustInvoke("tom", "lockMarble", "marble1", until, "escrow")
+
+	l.now = lockTestTime.Add(30 * time.Minute)
+	l.mustFail("marble1 is locked until "+until, "tom", "transferMarble", "marble1", "jerry")
+	l.mustFail("marble1 is locked", "tom", "delete", "marble1")
+	l.mustFail("marble1 is locked", "tom", "transferMarblesBasedOnColor", "blue", "jerry")
+	l.mustInvoke("tom", "transferMarblesBasedOnColor", "blue", "jerry", "skip")
+	if marbleDoc := readTestMarble(l, "marble1"); marbleDoc.Owner != "tom" || marbleDoc.Lock == nil {
+		t.Errorf("the locked marble moved to %s or lost its lock", marbleDoc.Owner)
+	}
+
+	// the lock expires at the transaction timestamp
+	l.now = lockTestTime.Add(time.Hour)
+	l.mustInvoke("tom", "transferMarble", "marble1", "jerry")
+}
+
+func TestLockDuration(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		until time.Time
+		want  string // error, or empty if the lock is set
+	}{
+		{name: "past", until: lockTestTime.Add(-time.Second), want: "must be after the transaction timestamp"},
+		{name: "now", until: lockTestTime, want: "must be after the transaction timestamp"},
+		{name: "longest", until: lockTestTime.AddDate(0, 0, maxLockDays)},
+		{name: "too long", until: lockTestTime.AddDate(0, 0, maxLockDays).Add(time.Second), want: "at most 366 days"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := newLockLedger(t)
+			if test.want != "" {
+				l.mustFail(test.want, "tom", "lockMarble", "marble1", test.until.Format(time.RFC3339), "vesting")
+				return
+			}
+			l.mustInvoke("tom", "lockMarble", "marble1", test.until.Format(time.RFC3339), "vesting")
+		})
+	}
+}
+
+func TestUnlockMarble(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		marble string
+		locker string
+		as     string
+		want   string // error, or empty if the marble is unlocked
+	}{
+		{name: "locker", marble: "marble1", locker: "tom", as: "tom"},
+		{name: "admin", marble: "marble1", locker: "tom", as: roleAdmin},
+		{name: "other identity", marble: "marble1", locker: "tom", as: "mallory", want: "Only the identity that locked marble marble1 or an admin"},
+		{name: "unregistered owner", marble: "marble2", locker: "mallory", as: "spike"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := newLockLedger(t)
+			l.mustInvoke(test.locker, "lockMarble", test.marble, lockTestTime.Add(time.Hour).Format(time.RFC3339), "escrow")
+			if test.want != "" {
+				l.mustFail(test.want, test.as, "unlockMarble", test.marble)
+				return
+			}
+			l.mustInvoke(test.as, "unlockMarble", test.marble)
+			if marbleDoc := readTestMarble(l, test.marble); marbleDoc.Lock != nil {
+				t.Errorf("%s is still locked", test.marble)
+			}
+		})
+	}
+}
+
+func TestUnparsableLockExpiry(t *testing.T) {
+	lock := &marbleLock{Until: "someday"}
+	if lock.active(lockTestTime) {
+		t.Error("a lock whose expiry does not parse is active")
+	}
+}
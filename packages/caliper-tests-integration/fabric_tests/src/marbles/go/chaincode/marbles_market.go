@@ -0,0 +1,409 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Balances and marketplace (mint is admin only) ====
+// Every registered owner has a balance of an integer amount of funds. Unregistered owners can
+// be acted for by anyone, so they cannot hold funds, and only registered owners can sell.
+// Spending from a balance, or selling a marble, requires the identity that registered its
+// owner (see assertActsFor).
+// buyMarble debits the buyer, credits the seller and transfers the marble in one transaction.
+// The buyer is the owner registered by the invoking identity, which must have registered
+// exactly one.
+// A listing only holds while the marble is unchanged: any later transaction on the marble, such
+// as a transfer, leaves it stale.
+//
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["mint","jerry","1000"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["transferFunds","jerry","tom","100"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["listMarbleForSale","marble1","250"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["delistMarble","marble1"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["buyMarble","marble1"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["balanceOf","jerry"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["readListing","marble1"]}'
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	// balanceObjectType and listingObjectType are the docTypes and composite key object types
+	// of owner balances and marble sale listings
+	balanceObjectType = "balance"
+	listingObjectType = "listing"
+)
+
+// balance is the funds held by an owner
+type balance struct {
+	ObjectType string `json:"docType"`
+	Owner      string `json:"owner"`
+	Amount     int64  `json:"amount"`
+}
+
+// listing offers a marble for sale at a fixed price
+type listing struct {
+	ObjectType string `json:"docType"`
+	Marble     string `json:"marble"`
+	Seller     string `json:"seller"` // owner of the marble when it was listed
+	Price      int64  `json:"price"`
+	ListedAt   string `json:"listedAt"`
+	MarbleTxID string `json:"marbleTxId"` // lastTxId of the marble when it was listed
+}
+
+// stale reports whether a marble has changed since it was listed, e.g. it was sold elsewhere
+// and bought back, so that the seller's consent to the price no longer holds
+func (l *listing) stale(marbleDoc *marble) bool {
+	return marbleDoc.LastTxID != l.MarbleTxID || marbleDoc.Owner != l.Seller
+}
+
+// parseAmount parses a positive integer amount of funds
+func parseAmount(arg, position string) (int64, error) {
+	amount, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || amount <= 0 {
+		return 0, fmt.Errorf("%s argument must be a positive integer", position)
+	}
+	return amount, nil
+}
+
+// getBalance reads the balance of an owner, which is zero if it has never received funds
+func getBalance(stub shim.ChaincodeStubInterface, owner string) (string, *balance, error) {
+	balanceKey, err := stub.CreateCompositeKey(balanceObjectType, []string{owner})
+	if err != nil {
+		return "", nil, err
+	}
+	balanceDoc := &balance{ObjectType: balanceObjectType, Owner: owner}
+	balanceAsBytes, err := stub.GetState(balanceKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get balance: %s", err.Error())
+	} else if balanceAsBytes != nil {
+		if err := json.Unmarshal(balanceAsBytes, balanceDoc); err != nil {
+			return "", nil, err
+		}
+	}
+	return balanceKey, balanceDoc, nil
+}
+
+// addToBalance changes the balance of an owner by a signed amount, failing if the owner is
+// not registered or if the balance would become negative or overflow
+func addToBalance(stub shim.ChaincodeStubInterface, owner string, amount int64) error {
+	ownerDoc, err := getOwner(stub, owner)
+	if err != nil {
+		return err
+	} else if ownerDoc == nil {
+		return fmt.Errorf("owner %s must be registered to hold a balance", owner)
+	}
+	balanceKey, balanceDoc, err := getBalance(stub, owner)
+	if err != nil {
+		return err
+	}
+	if amount < 0 && balanceDoc.Amount < -amount {
+		return fmt.Errorf("insufficient funds: %s has %d, needs %d", owner, balanceDoc.Amount, -amount)
+	}
+	if amount > 0 && balanceDoc.Amount > math.MaxInt64-amount {
+		return fmt.Errorf("balance of %s would overflow", owner)
+	}
+	balanceDoc.Amount += amount
+	balanceAsBytes, err := json.Marshal(balanceDoc)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(balanceKey, balanceAsBytes)
+}
+
+// moveFunds debits one owner and credits another
+func moveFunds(stub shim.ChaincodeStubInterface, from, to string, amount int64) error {
+	if from == to {
+		return fmt.Errorf("cannot move funds from %s to itself", from)
+	}
+	if err := addToBalance(stub, from, -amount); err != nil {
+		return err
+	}
+	return addToBalance(stub, to, amount)
+}
+
+// getListing reads the listing of a marble, or returns nil if it is not for sale
+func getListing(stub shim.ChaincodeStubInterface, marbleName string) (string, *listing, error) {
+	listingKey, err := stub.CreateCompositeKey(listingObjectType, []string{marbleName})
+	if err != nil {
+		return "", nil, err
+	}
+	listingAsBytes, err := stub.GetState(listingKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get listing: %s", err.Error())
+	} else if listingAsBytes == nil {
+		return listingKey, nil, nil
+	}
+	listingDoc := &listing{}
+	if err := json.Unmarshal(listingAsBytes, listingDoc); err != nil {
+		return "", nil, err
+	}
+	return listingKey, listingDoc, nil
+}
+
+// ============================================================
+// mint - create funds in the balance of an owner
+// ============================================================
+func (t *SimpleChaincode) mint(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0        1
+	// "jerry", "1000"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	owner := strings.ToLower(args[0])
+	if len(owner) <= 0 {
+		return shim.Error("1st argument must be a non-empty string")
+	}
+	amount, err := parseAmount(args[1], "2nd")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := addToBalance(stub, owner, amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// transferFunds - move funds from one owner to another
+// ============================================================
+func (t *SimpleChaincode) transferFunds(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0        1      2
+	// "jerry", "tom", "100"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	from := strings.ToLower(args[0])
+	to := strings.ToLower(args[1])
+	if len(from) <= 0 {
+		return shim.Error("1st argument must be a non-empty string")
+	}
+	if len(to) <= 0 {
+		return shim.Error("2nd argument must be a non-empty string")
+	}
+	amount, err := parseAmount(args[2], "3rd")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := assertActsFor(stub, from); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := moveFunds(stub, from, to, amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// balanceOf - read the balance of an owner
+// ============================================================
+func (t *SimpleChaincode) balanceOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the owner to query")
+	}
+
+	_, balanceDoc, err := getBalance(stub, strings.ToLower(args[0]))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	balanceAsBytes, err := json.Marshal(balanceDoc)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(balanceAsBytes)
+}
+
+// ==========================================================================================
+// listMarbleForSale - offer a marble for sale at a fixed price, replacing any previous listing
+// ==========================================================================================
+func (t *SimpleChaincode) listMarbleForSale(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//     0        1
+	// "marble1", "250"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	marbleName := args[0]
+	price, err := parseAmount(args[1], "2nd")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	marbleDoc, err := getMarble(stub, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if marbleDoc.Bag != "" {
+		return shim.Error("Marble " + marbleName + " is in bag " + marbleDoc.Bag + " and cannot be sold on its own")
+	}
+	sellerDoc, err := getOwner(stub, marbleDoc.Owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if sellerDoc == nil {
+		return shim.Error("Owner " + marbleDoc.Owner + " must be registered to sell marble " + marbleName)
+	}
+	if err := assertActsFor(stub, marbleDoc.Owner); err != nil {
+		return shim.Error(err.Error())
+	}
+	listedAt, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error("Failed to get transaction timestamp: " + err.Error())
+	}
+
+	listingKey, err := stub.CreateCompositeKey(listingObjectType, []string{marbleName})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	listingAsBytes, err := json.Marshal(&listing{listingObjectType, marbleName, marbleDoc.Owner, price, listedAt, marbleDoc.LastTxID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(listingKey, listingAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// delistMarble - withdraw a marble from sale
+// ============================================================
+func (t *SimpleChaincode) delistMarble(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	marbleName := args[0]
+
+	listingKey, listingDoc, err := getListing(stub, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if listingDoc == nil {
+		return shim.Error("Marble " + marbleName + " is not for sale")
+	}
+	// the seller may withdraw its listing; anyone may remove a listing left stale by a change
+	// to the marble
+	marbleDoc, err := getMarble(stub, marbleName)
+	if err == nil && !listingDoc.stale(marbleDoc) {
+		if err := assertActsFor(stub, listingDoc.Seller); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	if err := stub.DelState(listingKey); err != nil {
+		return shim.Error("Failed to delete state:" + err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// invokingBuyer returns the owner buying for the invoker: the one owner registered by the
+// invoking identity
+func invokingBuyer(stub shim.ChaincodeStubInterface) (string, error) {
+	invoker, err := cid.GetID(stub)
+	if err != nil {
+		return "", fmt.Errorf("failed to identify the invoker: %s", err.Error())
+	}
+	owners, err := ownersRegisteredBy(stub, invoker)
+	if err != nil {
+		return "", err
+	}
+	switch len(owners) {
+	case 0:
+		return "", fmt.Errorf("the invoker has not registered an owner to buy for")
+	case 1:
+		return owners[0], nil
+	}
+	return "", fmt.Errorf("the invoker registered several owners (%s) and cannot tell which one buys", strings.Join(owners, ", "))
+}
+
+// ==========================================================================================
+// buyMarble - buy a listed marble at its price: the buyer is debited, the seller credited and
+// the marble transferred to the buyer, all in one transaction. The buyer is the owner
+// registered by the invoking identity.
+// ==========================================================================================
+func (t *SimpleChaincode) buyMarble(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//     0
+	// "marble1"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	marbleName := args[0]
+	buyer, err := invokingBuyer(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Println("- start buyMarble ", marbleName, buyer)
+
+	listingKey, listingDoc, err := getListing(stub, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if listingDoc == nil {
+		return shim.Error("Marble " + marbleName + " is not for sale")
+	}
+	marbleDoc, err := getMarble(stub, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if listingDoc.stale(marbleDoc) {
+		return shim.Error("The listing of marble " + marbleName + " is stale: the marble changed at transaction " + marbleDoc.LastTxID)
+	}
+	if buyer == listingDoc.Seller {
+		return shim.Error("Marble " + marbleName + " is already owned by " + buyer)
+	}
+
+	if err := moveFunds(stub, buyer, listingDoc.Seller, listingDoc.Price); err != nil {
+		return shim.Error("Purchase failed: " + err.Error())
+	}
+	// The seller agreed by listing the marble, and the buyer by paying for it
+	if err := moveMarble(stub, marbleDoc, buyer); err != nil {
+		return shim.Error("Purchase failed: " + err.Error())
+	}
+	if err := stub.DelState(listingKey); err != nil {
+		return shim.Error("Failed to delete state:" + err.Error())
+	}
+
+	fmt.Println("- end buyMarble (success)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readListing - read the sale listing of a marble
+// ============================================================
+func (t *SimpleChaincode) readListing(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the marble to query")
+	}
+
+	_, listingDoc, err := getListing(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if listingDoc == nil {
+		return shim.Error("Marble " + args[0] + " is not for sale")
+	}
+	listingAsBytes, err := json.Marshal(listingDoc)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(listingAsBytes)
+}
@@ -0,0 +1,117 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+
+package chaincode
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// balanceOf reads the balance of an owner through balanceOf
+func balanceOf(l *testLedger, owner string) int64 {
+	l.t.Helper()
+	balanceDoc := &balance{}
+	if err := json.Unmarshal(l.mustInvoke("reader", "balanceOf", owner), balanceDoc); err != nil {
+		l.t.Fatal(err)
+	}
+	return balanceDoc.Amount
+}
+
+// newMarketLedger returns a ledger where tom lists marble1 for 250 and jerry holds 1000
+func newMarketLedger(t *testing.T) *testLedger {
+	l := newTestLedger(t)
+	l.mustInvoke("tom", "registerOwner", "tom", "Tom")
+	l.mustInvoke("jerry", "registerOwner", "jerry", "Jerry")
+	l.mustInvoke("tom", "initMarble", "marble1", "blue", "35", "tom")
+	l.mustInvoke(roleAdmin, "mint", "jerry", "1000")
+	l.mustInvoke("tom", "listMarbleForSale", "marble1", "250")
+	return l
+}
+
+func TestBuyMarble(t *testing.T) {
+	l := newMarketLedger(t)
+	l.mustInvoke("jerry", "buyMarble", "marble1")
+
+	if marbleDoc := readTestMarble(l, "marble1"); marbleDoc.Owner != "jerry" {
+		t.Errorf("marble1 is owned by %s, expected jerry", marbleDoc.Owner)
+	}
+	if amount := balanceOf(l, "jerry"); amount != 750 {
+		t.Errorf("jerry holds %d, expected 750", amount)
+	}
+	if amount := balanceOf(l, "tom"); amount != 250 {
+		t.Errorf("tom holds %d, expected 250", amount)
+	}
+	l.mustFail("not for sale", "jerry", "buyMarble", "marble1")
+}
+
+func TestBuyMarbleBuyer(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		as   string
+		want string
+	}{
+		{name: "no owner", as: "mallory", want: "has not registered an owner"},
+		{name: "several owners", as: "spike", want: "registered several owners (spike, tyke)"},
+		{name: "seller", as: "tom", want: "already owned by tom"},
+		{name: "insufficient funds", as: "butch", want: "insufficient"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := newMarketLedger(t)
+			l.mustInvoke("spike", "registerOwner", "spike", "Spike")
+			l.mustInvoke("spike", "registerOwner", "tyke", "Tyke")
+			l.mustInvoke("butch", "registerOwner", "butch", "Butch")
+			l.mustFail(test.want, test.as, "buyMarble", "marble1")
+		})
+	}
+}
+
+func TestStaleListing(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		change func(l *testLedger) // leaves marble1 with tom
+	}{
+		{name: "sold elsewhere and bought back", change: func(l *testLedger) {
+			l.mustInvoke("tom", "transferMarble", "marble1", "spike")
+			l.mustInvoke("spike", "transferMarble", "marble1", "tom")
+		}},
+		{name: "locked and unlocked", change: func(l *testLedger) {
+			l.mustInvoke("tom", "lockMarble", "marble1", "2100-01-01T00:00:00Z", "escrow")
+			l.mustInvoke("tom", "unlockMarble", "marble1")
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := newMarketLedger(t)
+			l.now = time.Date(2099, 6, 1, 0, 0, 0, 0, time.UTC)
+			test.change(l)
+
+			l.mustFail("The listing of marble marble1 is stale", "jerry", "buyMarble", "marble1")
+			if amount := balanceOf(l, "jerry"); amount != 1000 {
+				t.Errorf("jerry holds %d, expected 1000", amount)
+			}
+			// anyone may remove a stale listing
+			l.mustInvoke("jerry", "delistMarble", "marble1")
+			l.mustFail("not for sale", "jerry", "buyMarble", "marble1")
+		})
+	}
+}
+
+func TestDelistMarble(t *testing.T) {
+	l := newMarketLedger(t)
+	l.mustFail("only the registrant of owner tom or an admin may act for it", "jerry", "delistMarble", "marble1")
+	l.mustInvoke("tom", "delistMarble", "marble1")
+	l.mustFail("not for sale", "jerry", "buyMarble", "marble1")
+}
@@ -0,0 +1,337 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Metrics ====
+// When the CHAINCODE_METRICS_ADDRESS environment variable is set (e.g. ":9443"), the chaincode
+// process serves Prometheus text-format metrics at http://<address>/metrics:
+//
+//   marbles_chaincode_invocations_total{function}              invocations per function
+//   marbles_chaincode_errors_total{function,status}            error responses per function and status
+//   marbles_chaincode_invocation_duration_seconds{function}    execution duration histogram
+//   marbles_chaincode_state_operations_total{function,operation} state operations issued
+//   marbles_chaincode_response_bytes_total{function}           payload bytes returned
+//
+// Only the functions known to Invoke are used as label values; anything else is reported as
+// "unknown" to keep the label cardinality bounded.
+
+package chaincode
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	// metricsAddressEnv is the environment variable holding the metrics listen address
+	metricsAddressEnv = "CHAINCODE_METRICS_ADDRESS"
+
+	metricsNamespace = "marbles_chaincode"
+	unknownFunction  = "unknown"
+)
+
+// State operation label values
+const (
+	opGetState                      = "get_state"
+	opPutState                      = "put_state"
+	opDelState                      = "del_state"
+	opGetStateByRange               = "get_state_by_range"
+	opGetStateByPartialCompositeKey = "get_state_by_partial_composite_key"
+	opGetQueryResult                = "get_query_result"
+	opGetHistoryForKey              = "get_history_for_key"
+	opRangeIteration                = "range_iteration"
+	opGetPrivateData                = "get_private_data"
+	opGetPrivateDataHash            = "get_private_data_hash"
+	opPutPrivateData                = "put_private_data"
+	opDelPrivateData                = "del_private_data"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the duration histogram buckets
+var durationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// durationHistogram is a histogram of invocation durations
+type durationHistogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// functionMetrics holds the metrics of a single chaincode function
+type functionMetrics struct {
+	invocations   uint64
+	errors        map[int32]uint64
+	duration      durationHistogram
+	stateOps      map[string]uint64
+	responseBytes uint64
+}
+
+// chaincodeMetrics collects invocation metrics. It is safe for concurrent use.
+type chaincodeMetrics struct {
+	mutex     sync.Mutex
+	functions map[string]*functionMetrics
+}
+
+func newChaincodeMetrics() *chaincodeMetrics {
+	return &chaincodeMetrics{functions: map[string]*functionMetrics{}}
+}
+
+// observe records a finished invocation along with the state operations it issued
+func (m *chaincodeMetrics) observe(function string, response pb.Response, duration time.Duration, stateOps map[string]uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	f, ok := m.functions[function]
+	if !ok {
+		f = &functionMetrics{
+			errors:   map[int32]uint64{},
+			duration: durationHistogram{counts: make([]uint64, len(durationBuckets))},
+			stateOps: map[string]uint64{},
+		}
+		m.functions[function] = f
+	}
+
+	f.invocations++
+	if response.Status >= shim.ERRORTHRESHOLD {
+		f.errors[response.Status]++
+	}
+	seconds := duration.Seconds()
+	f.duration.count++
+	f.duration.sum += seconds
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			f.duration.counts[i]++
+			break
+		}
+	}
+	for op, count := range stateOps {
+		f.stateOps[op] += count
+	}
+	f.responseBytes += uint64(len(response.Payload))
+}
+
+// writeTo writes all metrics in the Prometheus text exposition format
+func (m *chaincodeMetrics) writeTo(w io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	functions := make([]string, 0, len(m.functions))
+	for function := range m.functions {
+		functions = append(functions, function)
+	}
+	sort.Strings(functions)
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# HELP %s_invocations_total Number of chaincode invocations.\n", metricsNamespace)
+	fmt.Fprintf(&b, "# TYPE %s_invocations_total counter\n", metricsNamespace)
+	for _, function := range functions {
+		fmt.Fprintf(&b, "%s_invocations_total{function=%q} %d\n", metricsNamespace, function, m.functions[function].invocations)
+	}
+
+	fmt.Fprintf(&b, "# HELP %s_errors_total Number of chaincode invocations that returned an error, by status.\n", metricsNamespace)
+	fmt.Fprintf(&b, "# TYPE %s_errors_total counter\n", metricsNamespace)
+	for _, function := range functions {
+		f := m.functions[function]
+		statuses := make([]int, 0, len(f.errors))
+		for status := range f.errors {
+			statuses = append(statuses, int(status))
+		}
+		sort.Ints(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(&b, "%s_errors_total{function=%q,status=\"%d\"} %d\n", metricsNamespace, function, status, f.errors[int32(status)])
+		}
+	}
+
+	fmt.Fprintf(&b, "# HELP %s_invocation_duration_seconds Chaincode execution duration.\n", metricsNamespace)
+	fmt.Fprintf(&b, "# TYPE %s_invocation_duration_seconds histogram\n", metricsNamespace)
+	for _, function := range functions {
+		h := m.functions[function].duration
+		cumulative := uint64(0)
+		for i, bound := range durationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "%s_invocation_duration_seconds_bucket{function=%q,le=%q} %d\n", metricsNamespace, function, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(&b, "%s_invocation_duration_seconds_bucket{function=%q,le=\"+Inf\"} %d\n", metricsNamespace, function, h.count)
+		fmt.Fprintf(&b, "%s_invocation_duration_seconds_sum{function=%q} %s\n", metricsNamespace, function, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "%s_invocation_duration_seconds_count{function=%q} %d\n", metricsNamespace, function, h.count)
+	}
+
+	fmt.Fprintf(&b, "# HELP %s_state_operations_total Number of state operations issued, by operation.\n", metricsNamespace)
+	fmt.Fprintf(&b, "# TYPE %s_state_operations_total counter\n", metricsNamespace)
+	for _, function := range functions {
+		f := m.functions[function]
+		ops := make([]string, 0, len(f.stateOps))
+		for op := range f.stateOps {
+			ops = append(ops, op)
+		}
+		sort.Strings(ops)
+		for _, op := range ops {
+			fmt.Fprintf(&b, "%s_state_operations_total{function=%q,operation=%q} %d\n", metricsNamespace, function, op, f.stateOps[op])
+		}
+	}
+
+	fmt.Fprintf(&b, "# HELP %s_response_bytes_total Number of payload bytes returned.\n", metricsNamespace)
+	fmt.Fprintf(&b, "# TYPE %s_response_bytes_total counter\n", metricsNamespace)
+	for _, function := range functions {
+		fmt.Fprintf(&b, "%s_response_bytes_total{function=%q} %d\n", metricsNamespace, function, m.functions[function].responseBytes)
+	}
+
+	io.WriteString(w, b.String())
+}
+
+// ServeHTTP serves the metrics endpoint
+func (m *chaincodeMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.writeTo(w)
+}
+
+// serveMetrics starts the metrics HTTP endpoint in the background
+func serveMetrics(address string, metrics *chaincodeMetrics) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	go func() {
+		if err := http.ListenAndServe(address, mux); err != nil {
+			fmt.Printf("Error serving metrics on %s: %s\n", address, err)
+		}
+	}()
+}
+
+// meteredStub counts the state operations issued by a single invocation. Operations that are
+// not overridden are passed through to the wrapped stub without being counted.
+type meteredStub struct {
+	shim.ChaincodeStubInterface
+	ops map[string]uint64
+}
+
+func newMeteredStub(stub shim.ChaincodeStubInterface) *meteredStub {
+	return &meteredStub{ChaincodeStubInterface: stub, ops: map[string]uint64{}}
+}
+
+func (s *meteredStub) GetState(key string) ([]byte, error) {
+	s.ops[opGetState]++
+	return s.ChaincodeStubInterface.GetState(key)
+}
+
+func (s *meteredStub) PutState(key string, value []byte) error {
+	s.ops[opPutState]++
+	return s.ChaincodeStubInterface.PutState(key, value)
+}
+
+func (s *meteredStub) DelState(key string) error {
+	s.ops[opDelState]++
+	return s.ChaincodeStubInterface.DelState(key)
+}
+
+func (s *meteredStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	s.ops[opGetStateByRange]++
+	iterator, err := s.ChaincodeStubInterface.GetStateByRange(startKey, endKey)
+	return s.wrapIterator(iterator), err
+}
+
+func (s *meteredStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	s.ops[opGetStateByRange]++
+	iterator, metadata, err := s.ChaincodeStubInterface.GetStateByRangeWithPagination(startKey, endKey, pageSize, bookmark)
+	return s.wrapIterator(iterator), metadata, err
+}
+
+func (s *meteredStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	s.ops[opGetStateByPartialCompositeKey]++
+	iterator, err := s.ChaincodeStubInterface.GetStateByPartialCompositeKey(objectType, keys)
+	return s.wrapIterator(iterator), err
+}
+
+func (s *meteredStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	s.ops[opGetStateByPartialCompositeKey]++
+	iterator, metadata, err := s.ChaincodeStubInterface.GetStateByPartialCompositeKeyWithPagination(objectType, keys, pageSize, bookmark)
+	return s.wrapIterator(iterator), metadata, err
+}
+
+func (s *meteredStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	s.ops[opGetQueryResult]++
+	iterator, err := s.ChaincodeStubInterface.GetQueryResult(query)
+	return s.wrapIterator(iterator), err
+}
+
+func (s *meteredStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	s.ops[opGetQueryResult]++
+	iterator, metadata, err := s.ChaincodeStubInterface.GetQueryResultWithPagination(query, pageSize, bookmark)
+	return s.wrapIterator(iterator), metadata, err
+}
+
+func (s *meteredStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	s.ops[opGetHistoryForKey]++
+	iterator, err := s.ChaincodeStubInterface.GetHistoryForKey(key)
+	if iterator == nil {
+		return nil, err
+	}
+	return &meteredHistoryIterator{iterator, s.ops}, err
+}
+
+func (s *meteredStub) GetPrivateData(collection, key string) ([]byte, error) {
+	s.ops[opGetPrivateData]++
+	return s.ChaincodeStubInterface.GetPrivateData(collection, key)
+}
+
+func (s *meteredStub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	s.ops[opGetPrivateDataHash]++
+	return s.ChaincodeStubInterface.GetPrivateDataHash(collection, key)
+}
+
+func (s *meteredStub) PutPrivateData(collection, key string, value []byte) error {
+	s.ops[opPutPrivateData]++
+	return s.ChaincodeStubInterface.PutPrivateData(collection, key, value)
+}
+
+func (s *meteredStub) DelPrivateData(collection, key string) error {
+	s.ops[opDelPrivateData]++
+	return s.ChaincodeStubInterface.DelPrivateData(collection, key)
+}
+
+func (s *meteredStub) wrapIterator(iterator shim.StateQueryIteratorInterface) shim.StateQueryIteratorInterface {
+	if iterator == nil {
+		return nil
+	}
+	return &meteredStateIterator{iterator, s.ops}
+}
+
+// meteredStateIterator counts the results read from a state query iterator
+type meteredStateIterator struct {
+	shim.StateQueryIteratorInterface
+	ops map[string]uint64
+}
+
+func (i *meteredStateIterator) Next() (*queryresult.KV, error) {
+	i.ops[opRangeIteration]++
+	return i.StateQueryIteratorInterface.Next()
+}
+
+// meteredHistoryIterator counts the results read from a history query iterator
+type meteredHistoryIterator struct {
+	shim.HistoryQueryIteratorInterface
+	ops map[string]uint64
+}
+
+func (i *meteredHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	i.ops[opRangeIteration]++
+	return i.HistoryQueryIteratorInterface.Next()
+}
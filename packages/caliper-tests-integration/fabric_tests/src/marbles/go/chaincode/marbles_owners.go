@@ -0,0 +1,463 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Owner registry ====
+// Marbles of a registered owner can only be transferred or deleted by the identity that
+// registered it, or by an admin. Marbles of unregistered owners remain open to anyone.
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["registerOwner","tom","Tom","{\"email\":\"tom@example.com\"}"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["updateOwnerProfile","tom","Tom Cat",""]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["setOwnerPolicy","{\"requireRegistered\":true}"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["readOwner","tom"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["listOwners","10",""]}'
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	// ownerObjectType is the docType and composite key object type of owner documents
+	ownerObjectType = "owner"
+
+	// registrantIndex is the composite key index of owners by the unique ID of the identity
+	// that registered them
+	registrantIndex = "registrant~owner"
+
+	// ownerPolicyConfig is the configuration document holding the owner policy
+	ownerPolicyConfig = "ownerPolicy"
+)
+
+// owner is a registered marble owner. The ID is the lower-cased name used as marble owner.
+type owner struct {
+	ObjectType   string            `json:"docType"`
+	ID           string            `json:"id"`
+	DisplayName  string            `json:"displayName"`
+	Profile      map[string]string `json:"profile"`
+	RegisteredBy string            `json:"registeredBy"` // unique ID of the registering identity
+	CreatedAt    string            `json:"createdAt"`
+	UpdatedAt    string            `json:"updatedAt"`
+	LastTxID     string            `json:"lastTxId"`
+}
+
+// ownerPolicy controls how marble functions treat owners
+type ownerPolicy struct {
+	// RequireRegistered makes initMarble and transferMarble reject owners that are not registered
+	RequireRegistered bool `json:"requireRegistered"`
+}
+
+// parseOwnerProfile decodes an optional JSON object of string values
+func parseOwnerProfile(profileJSON string) (map[string]string, error) {
+	profile := map[string]string{}
+	if len(profileJSON) > 0 {
+		if err := json.Unmarshal([]byte(profileJSON), &profile); err != nil {
+			return nil, fmt.Errorf("profile must be a JSON object of strings: %s", err.Error())
+		}
+		if profile == nil {
+			profile = map[string]string{}
+		}
+	}
+	return profile, nil
+}
+
+// getOwner reads a registered owner, or returns nil if the owner is not registered
+func getOwner(stub shim.ChaincodeStubInterface, ownerID string) (*owner, error) {
+	ownerKey, err := stub.CreateCompositeKey(ownerObjectType, []string{ownerID})
+	if err != nil {
+		return nil, err
+	}
+	ownerAsBytes, err := stub.GetState(ownerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get owner: %s", err.Error())
+	} else if ownerAsBytes == nil {
+		return nil, nil
+	}
+	ownerDoc := &owner{}
+	if err := json.Unmarshal(ownerAsBytes, ownerDoc); err != nil {
+		return nil, err
+	}
+	return ownerDoc, nil
+}
+
+// putOwner stores an owner document, stamping it with the transaction's timestamp and ID
+func putOwner(stub shim.ChaincodeStubInterface, ownerDoc *owner) error {
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return fmt.Errorf("failed to get transaction timestamp: %s", err.Error())
+	}
+	if ownerDoc.CreatedAt == "" {
+		ownerDoc.CreatedAt = timestamp
+	}
+	ownerDoc.UpdatedAt = timestamp
+	ownerDoc.LastTxID = stub.GetTxID()
+
+	ownerKey, err := stub.CreateCompositeKey(ownerObjectType, []string{ownerDoc.ID})
+	if err != nil {
+		return err
+	}
+	ownerJSONasBytes, err := json.Marshal(ownerDoc)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(ownerKey, ownerJSONasBytes)
+}
+
+// assertOwnerRegistered checks that a marble owner is registered, if the owner policy requires it
+func assertOwnerRegistered(stub shim.ChaincodeStubInterface, ownerID string) error {
+	policy := &ownerPolicy{}
+	if _, err := getConfig(stub, ownerPolicyConfig, policy); err != nil {
+		return err
+	}
+	if !policy.RequireRegistered {
+		return nil
+	}
+	ownerDoc, err := getOwner(stub, ownerID)
+	if err != nil {
+		return err
+	} else if ownerDoc == nil {
+		return fmt.Errorf("owner %s is not registered", ownerID)
+	}
+	return nil
+}
+
+// assertActsFor checks that the invoker may act for an owner, e.g. spend its funds. A
+// registered owner can only be acted for by the identity that registered it, or by an admin.
+// Unregistered owners are plain names, and anyone may act for them, as for marble transfers;
+// for that reason they cannot hold balances (see addToBalance).
+func assertActsFor(stub shim.ChaincodeStubInterface, ownerID string) error {
+	ownerDoc, err := getOwner(stub, ownerID)
+	if err != nil || ownerDoc == nil {
+		return err
+	}
+	invoker, err := cid.GetID(stub)
+	if err != nil {
+		return fmt.Errorf("failed to identify the invoker: %s", err.Error())
+	}
+	if invoker == ownerDoc.RegisteredBy {
+		return nil
+	}
+	if err := assertRole(stub, roleAdmin); err != nil {
+		return fmt.Errorf("only the registrant of owner %s or an admin may act for it", ownerID)
+	}
+	return nil
+}
+
+// ownersRegisteredBy returns the IDs of the owners registered by an identity, in order
+func ownersRegisteredBy(stub shim.ChaincodeStubInterface, registrant string) ([]string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(registrantIndex, []string{registrant})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	owners := []string{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		owners = append(owners, compositeKeyParts[1])
+	}
+	return owners, nil
+}
+
+// ownerHoldsAssets reports whether an owner holds any marble or other asset. A positive
+// holdings counter answers directly; otherwise the simple keys are scanned, since counters
+// are only kept while tracking is enabled and miss assets created before it was.
+func ownerHoldsAssets(stub shim.ChaincodeStubInterface, ownerID string) (bool, error) {
+	policy, err := loadQuotaPolicy(stub)
+	if err != nil {
+		return false, err
+	}
+	if policy.tracksHoldings() {
+		_, holdings, err := getQuotaCounter(stub, holdingsObjectType, []string{ownerID})
+		if err != nil {
+			return false, err
+		} else if holdings.Count > 0 {
+			return true, nil
+		}
+	}
+
+	resultsIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return false, err
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return false, err
+		}
+		var doc struct {
+			ObjectType string `json:"docType"`
+			Owner      string `json:"owner"`
+		}
+		if json.Unmarshal(queryResponse.Value, &doc) == nil && doc.ObjectType != "" && doc.Owner == ownerID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ==========================================================================
+// registerOwner - register a marble owner. The invoking identity is recorded
+// as the owner's registrant and may update the owner's profile. Names that
+// already hold funds or marbles can only be registered by an admin.
+// ==========================================================================
+func (t *SimpleChaincode) registerOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1          2
+	// "tom", "Tom", "{\"email\":\"tom@example.com\"}"
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	ownerID := strings.ToLower(args[0])
+	if len(ownerID) <= 0 {
+		return shim.Error("1st argument must be a non-empty string")
+	}
+	profileJSON := ""
+	if len(args) == 3 {
+		profileJSON = args[2]
+	}
+	profile, err := parseOwnerProfile(profileJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Println("- start registerOwner ", ownerID)
+
+	existing, err := getOwner(stub, ownerID)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if existing != nil {
+		return shim.Error("This owner already exists: " + ownerID)
+	}
+	// The registrant acts for the owner, so names that already hold funds or marbles are
+	// left to admins; anyone else could take them over by registering first
+	if err := assertRole(stub, roleAdmin); err != nil {
+		_, balanceDoc, err := getBalance(stub, ownerID)
+		if err != nil {
+			return shim.Error(err.Error())
+		} else if balanceDoc.Amount > 0 {
+			return shim.Error("Owner " + ownerID + " already holds a balance and can only be registered by an admin")
+		}
+		holdsAssets, err := ownerHoldsAssets(stub, ownerID)
+		if err != nil {
+			return shim.Error(err.Error())
+		} else if holdsAssets {
+			return shim.Error("Owner " + ownerID + " already holds marbles and can only be registered by an admin")
+		}
+	}
+	registrant, err := cid.GetID(stub)
+	if err != nil {
+		return shim.Error("Failed to identify the invoker: " + err.Error())
+	}
+
+	ownerDoc := &owner{
+		ObjectType:   ownerObjectType,
+		ID:           ownerID,
+		DisplayName:  args[1],
+		Profile:      profile,
+		RegisteredBy: registrant,
+	}
+	if err := putOwner(stub, ownerDoc); err != nil {
+		return shim.Error(err.Error())
+	}
+	registrantIndexKey, err := stub.CreateCompositeKey(registrantIndex, []string{registrant, ownerID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(registrantIndexKey, []byte{0x00}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end registerOwner")
+	return shim.Success(nil)
+}
+
+// ==========================================================================
+// updateOwnerProfile - replace the display name of an owner, and its profile
+// if given. Only the identity that registered the owner, or an admin, may do so.
+// ==========================================================================
+func (t *SimpleChaincode) updateOwnerProfile(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0        1              2
+	// "tom", "Tom Cat", "{\"email\":\"tom@example.com\"}"
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	ownerID := strings.ToLower(args[0])
+
+	ownerDoc, err := getOwner(stub, ownerID)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if ownerDoc == nil {
+		return shim.Error("Owner does not exist: " + ownerID)
+	}
+	invoker, err := cid.GetID(stub)
+	if err != nil {
+		return shim.Error("Failed to identify the invoker: " + err.Error())
+	}
+	if invoker != ownerDoc.RegisteredBy {
+		if err := assertRole(stub, roleAdmin); err != nil {
+			return shim.Error("Only the registrant of owner " + ownerID + " or an admin may update its profile")
+		}
+	}
+
+	ownerDoc.DisplayName = args[1]
+	if len(args) == 3 {
+		ownerDoc.Profile, err = parseOwnerProfile(args[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if err := putOwner(stub, ownerDoc); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ==========================================================================
+// readOwner - read a registered owner
+// ==========================================================================
+func (t *SimpleChaincode) readOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the owner to query")
+	}
+	ownerID := strings.ToLower(args[0])
+
+	ownerDoc, err := getOwner(stub, ownerID)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if ownerDoc == nil {
+		return shim.Error("Owner does not exist: " + ownerID)
+	}
+	ownerAsBytes, err := json.Marshal(ownerDoc)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(ownerAsBytes)
+}
+
+// ==========================================================================
+// listOwners - list registered owners in ID order, a page at a time if a
+// page size is given. Records are keyed by owner ID.
+// ==========================================================================
+func (t *SimpleChaincode) listOwners(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0      1
+	// "10", "bookmark"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	pageSize := 0
+	if len(args[0]) > 0 {
+		var err error
+		pageSize, err = strconv.Atoi(args[0])
+		if err != nil || pageSize < 0 || pageSize > maxReadMarblesCount {
+			return shim.Error(fmt.Sprintf("1st argument must be a page size between 0 and %d", maxReadMarblesCount))
+		}
+	}
+	bookmark := args[1]
+
+	result := &richQueryResult{}
+	var resultsIterator shim.StateQueryIteratorInterface
+	var err error
+	if pageSize > 0 {
+		var responseMetadata *pb.QueryResponseMetadata
+		resultsIterator, responseMetadata, err = stub.GetStateByPartialCompositeKeyWithPagination(ownerObjectType, []string{}, int32(pageSize), bookmark)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Bookmark = responseMetadata.Bookmark
+	} else {
+		resultsIterator, err = stub.GetStateByPartialCompositeKey(ownerObjectType, []string{})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	defer resultsIterator.Close()
+
+	result.Records, err = collectQueryRecords(resultsIterator)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for i := range result.Records {
+		_, keyParts, err := stub.SplitCompositeKey(result.Records[i].Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Records[i].Key = keyParts[0]
+	}
+	result.FetchedRecordsCount = int32(len(result.Records))
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// ==========================================================================
+// setOwnerPolicy - store the policy applied to marble owners
+// ==========================================================================
+func (t *SimpleChaincode) setOwnerPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "{\"requireRegistered\":true}"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	policy := &ownerPolicy{}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(policy); err != nil {
+		return shim.Error("Invalid owner policy: " + err.Error())
+	}
+
+	if err := putConfig(stub, ownerPolicyConfig, policy); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ==========================================================================
+// getOwnerPolicy - read the policy applied to marble owners
+// ==========================================================================
+func (t *SimpleChaincode) getOwnerPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	policy := &ownerPolicy{}
+	if _, err := getConfig(stub, ownerPolicyConfig, policy); err != nil {
+		return shim.Error(err.Error())
+	}
+	policyAsBytes, err := json.Marshal(policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(policyAsBytes)
+}
@@ -0,0 +1,82 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+
+package chaincode
+
+import (
+	"testing"
+)
+
+func TestRegisterOwnerHoldingMarbles(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		trackHoldings bool
+		owner         string
+		as            string
+		want          string // error, or empty if the registration succeeds
+	}{
+		{name: "scanned holder", owner: "tom", as: "mallory", want: "already holds marbles"},
+		{name: "counted holder", trackHoldings: true, owner: "tom", as: "mallory", want: "already holds marbles"},
+		{name: "holder by admin", owner: "tom", as: roleAdmin},
+		{name: "new name", owner: "spike", as: "mallory"},
+		{name: "new name while tracking", trackHoldings: true, owner: "spike", as: "mallory"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := newTestLedger(t)
+			if test.trackHoldings {
+				l.mustInvoke(roleAdmin, "setQuotaPolicy", `{"trackHoldings":true}`)
+			}
+			l.mustInvoke("tom", "initMarble", "marble1", "blue", "35", "tom")
+
+			if test.want != "" {
+				l.mustFail(test.want, test.as, "registerOwner", test.owner, "Tom")
+				// the owner stays unregistered, so its marbles are not taken over
+				l.mustInvoke("tom", "transferMarble", "marble1", "jerry")
+				return
+			}
+			l.mustInvoke(test.as, "registerOwner", test.owner, "Tom")
+		})
+	}
+}
+
+func TestRegisteredOwnerMarbles(t *testing.T) {
+	for _, test := range []struct {
+		function string
+		args     []string
+	}{
+		{function: "transferMarble", args: []string{"marble1", "jerry"}},
+		{function: "delete", args: []string{"marble1"}},
+		{function: "transferMarblesBasedOnColor", args: []string{"blue", "jerry"}},
+	} {
+		t.Run(test.function, func(t *testing.T) {
+			l := newTestLedger(t)
+			l.mustInvoke("tom", "registerOwner", "tom", "Tom")
+			l.mustInvoke("tom", "initMarble", "marble1", "blue", "35", "tom")
+			l.mustInvoke("spike", "initMarble", "marble2", "red", "50", "spike")
+
+			l.mustFail("only the registrant of owner tom", "mallory", test.function, test.args...)
+			l.mustInvoke("tom", test.function, test.args...)
+			// the marble of the unregistered owner is open to anyone
+			l.mustInvoke("mallory", "transferMarble", "marble2", "mallory")
+		})
+	}
+}
+
+func TestRegisteredOwnerMarblesByAdmin(t *testing.T) {
+	l := newTestLedger(t)
+	l.mustInvoke("tom", "registerOwner", "tom", "Tom")
+	l.mustInvoke("tom", "initMarble", "marble1", "blue", "35", "tom")
+	l.mustInvoke(roleAdmin, "transferMarble", "marble1", "jerry")
+}
@@ -0,0 +1,288 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Sale with a privately agreed price ====
+// The seller and the buyer each store the agreed price in their own organization's implicit
+// private data collection. The price never appears on the channel ledger; only its hash does.
+//
+// export PRICE=$(echo -n "{\"price\":100,\"tradeId\":\"trade-42\"}" | base64 | tr -d \\n)
+// (as Org2) peer chaincode invoke -C myc1 -n marbles -c '{"Args":["agreeToBuyMarble","marble1","jerry"]}' --transient "{\"marble_price\":\"$PRICE\"}"
+// (as Org1) peer chaincode invoke -C myc1 -n marbles -c '{"Args":["agreeToSellMarble","marble1"]}' --transient "{\"marble_price\":\"$PRICE\"}"
+// (as Org1) peer chaincode invoke -C myc1 -n marbles -c '{"Args":["sellMarbleWithAgreedPrice","marble1","jerry"]}'
+
+package chaincode
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	// priceTransientKey is the transient map entry holding the agreed price
+	priceTransientKey = "marble_price"
+
+	// agreedPriceObjectType is the composite key object type of private price agreements
+	agreedPriceObjectType = "agreedPrice"
+
+	// buyAgreementObjectType is the composite key object type of the public record naming the
+	// buyer of a marble and the organization holding the buyer's price agreement
+	buyAgreementObjectType = "buyAgreement"
+)
+
+// agreedPrice is the private record written by both parties. The trade ID salts the record so
+// that the price cannot be recovered from its public hash by guessing.
+type agreedPrice struct {
+	MarbleName string `json:"marbleName"`
+	Price      int    `json:"price"`
+	TradeID    string `json:"tradeId"`
+}
+
+// buyAgreement is the public record written by the buyer
+type buyAgreement struct {
+	ObjectType string `json:"docType"`
+	MarbleName string `json:"marbleName"`
+	Buyer      string `json:"buyer"`
+	BuyerMSP   string `json:"buyerMSP"`
+}
+
+// implicitCollectionName returns the name of an organization's implicit private data collection
+func implicitCollectionName(mspID string) string {
+	return "_implicit_org_" + mspID
+}
+
+// verifyClientOrgMatchesPeerOrg checks that the invoker belongs to the organization of the
+// endorsing peer, so that an organization only writes to its own implicit collection
+func verifyClientOrgMatchesPeerOrg(stub shim.ChaincodeStubInterface) (string, error) {
+	clientMSPID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the invoker's MSP ID: %s", err.Error())
+	}
+	peerMSPID, err := shim.GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the peer's MSP ID: %s", err.Error())
+	}
+	if clientMSPID != peerMSPID {
+		return "", fmt.Errorf("client from org %s is not authorized to write private data of org %s", clientMSPID, peerMSPID)
+	}
+	return clientMSPID, nil
+}
+
+// putAgreedPrice reads the agreed price from the transient map and stores it in the invoker's
+// implicit private data collection. It returns the invoker's MSP ID.
+func putAgreedPrice(stub shim.ChaincodeStubInterface, marbleName string) (string, error) {
+	transientMap, err := stub.GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("failed to get transient map: %s", err.Error())
+	}
+	priceAsBytes, ok := transientMap[priceTransientKey]
+	if !ok {
+		return "", fmt.Errorf("%s must be a key in the transient map", priceTransientKey)
+	}
+
+	price := agreedPrice{}
+	if err := json.Unmarshal(priceAsBytes, &price); err != nil {
+		return "", fmt.Errorf("failed to decode %s: %s", priceTransientKey, err.Error())
+	}
+	if price.Price <= 0 {
+		return "", fmt.Errorf("price must be a positive integer")
+	}
+	if len(price.TradeID) <= 0 {
+		return "", fmt.Errorf("tradeId must be a non-empty string")
+	}
+	// both parties must write byte-identical records for their hashes to match
+	price.MarbleName = marbleName
+
+	mspID, err := verifyClientOrgMatchesPeerOrg(stub)
+	if err != nil {
+		return "", err
+	}
+	priceKey, err := stub.CreateCompositeKey(agreedPriceObjectType, []string{marbleName})
+	if err != nil {
+		return "", err
+	}
+	priceJSONasBytes, err := json.Marshal(price)
+	if err != nil {
+		return "", err
+	}
+	if err := stub.PutPrivateData(implicitCollectionName(mspID), priceKey, priceJSONasBytes); err != nil {
+		return "", fmt.Errorf("failed to put agreed price: %s", err.Error())
+	}
+	return mspID, nil
+}
+
+// ==============================================================================================
+// agreeToSellMarble - the seller stores the agreed price of a marble in its own implicit
+// private data collection. The price is passed in the transient map as marble_price. The
+// invoker must act for the marble's owner.
+// ==============================================================================================
+func (t *SimpleChaincode) agreeToSellMarble(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "name"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	marbleName := args[0]
+
+	marbleDoc, err := getMarble(stub, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := assertActsFor(stub, marbleDoc.Owner); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if _, err := putAgreedPrice(stub, marbleName); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ==============================================================================================
+// agreeToBuyMarble - the buyer stores the agreed price of a marble in its own implicit private
+// data collection, and publicly records who the buyer is and which organization holds the
+// buyer's agreement. The price is passed in the transient map as marble_price.
+// ==============================================================================================
+func (t *SimpleChaincode) agreeToBuyMarble(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "name", "bob"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	marbleName := args[0]
+	buyer := strings.ToLower(args[1])
+	if len(buyer) <= 0 {
+		return shim.Error("2nd argument must be a non-empty string")
+	}
+
+	if _, err := getMarble(stub, marbleName); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	buyerMSP, err := putAgreedPrice(stub, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	agreementKey, err := stub.CreateCompositeKey(buyAgreementObjectType, []string{marbleName})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	agreementJSONasBytes, err := json.Marshal(&buyAgreement{buyAgreementObjectType, marbleName, buyer, buyerMSP})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(agreementKey, agreementJSONasBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ==============================================================================================
+// sellMarbleWithAgreedPrice - transfer a marble to its buyer once the seller's and the buyer's
+// private price agreements match. The prices themselves are never read; the chaincode compares
+// the hashes of both private records, which are available on every peer of the channel.
+// Must be invoked by a member of the seller's organization that acts for the marble's owner.
+// ==============================================================================================
+func (t *SimpleChaincode) sellMarbleWithAgreedPrice(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "name", "bob"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	marbleName := args[0]
+	buyer := strings.ToLower(args[1])
+	fmt.Println("- start sellMarbleWithAgreedPrice ", marbleName, buyer)
+
+	agreementKey, err := stub.CreateCompositeKey(buyAgreementObjectType, []string{marbleName})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	agreementAsBytes, err := stub.GetState(agreementKey)
+	if err != nil {
+		return shim.Error("Failed to get buy agreement:" + err.Error())
+	} else if agreementAsBytes == nil {
+		return shim.Error("No buyer has agreed to buy marble " + marbleName)
+	}
+	agreement := buyAgreement{}
+	if err := json.Unmarshal(agreementAsBytes, &agreement); err != nil {
+		return shim.Error(err.Error())
+	}
+	if agreement.Buyer != buyer {
+		return shim.Error("Marble " + marbleName + " was agreed to be sold to " + agreement.Buyer + ", not " + buyer)
+	}
+
+	sellerMSP, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error("Failed to get the invoker's MSP ID: " + err.Error())
+	}
+	sellerCollection := implicitCollectionName(sellerMSP)
+	buyerCollection := implicitCollectionName(agreement.BuyerMSP)
+
+	priceKey, err := stub.CreateCompositeKey(agreedPriceObjectType, []string{marbleName})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	sellerPriceHash, err := stub.GetPrivateDataHash(sellerCollection, priceKey)
+	if err != nil {
+		return shim.Error("Failed to get seller's price hash: " + err.Error())
+	} else if sellerPriceHash == nil {
+		return shim.Error("Seller has not agreed on a price for marble " + marbleName)
+	}
+	buyerPriceHash, err := stub.GetPrivateDataHash(buyerCollection, priceKey)
+	if err != nil {
+		return shim.Error("Failed to get buyer's price hash: " + err.Error())
+	} else if buyerPriceHash == nil {
+		return shim.Error("Buyer has not agreed on a price for marble " + marbleName)
+	}
+	if !bytes.Equal(sellerPriceHash, buyerPriceHash) {
+		return shim.Error("Seller's and buyer's agreed prices do not match for marble " + marbleName)
+	}
+
+	// The matching price agreements authorize the transfer to the buyer
+	marbleDoc, err := getMarble(stub, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := assertActsFor(stub, marbleDoc.Owner); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := moveMarble(stub, marbleDoc, buyer); err != nil {
+		return shim.Error("Transfer failed: " + err.Error())
+	}
+
+	// the agreements are settled, remove them so they cannot be replayed
+	if err := stub.DelPrivateData(sellerCollection, priceKey); err != nil {
+		return shim.Error("Failed to delete seller's agreed price: " + err.Error())
+	}
+	if buyerCollection != sellerCollection {
+		if err := stub.DelPrivateData(buyerCollection, priceKey); err != nil {
+			return shim.Error("Failed to delete buyer's agreed price: " + err.Error())
+		}
+	}
+	if err := stub.DelState(agreementKey); err != nil {
+		return shim.Error("Failed to delete state:" + err.Error())
+	}
+
+	fmt.Println("- end sellMarbleWithAgreedPrice (success)")
+	return shim.Success(nil)
+}
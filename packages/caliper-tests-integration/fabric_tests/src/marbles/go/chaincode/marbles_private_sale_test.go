@@ -0,0 +1,78 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+
+package chaincode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-samples/chaincode/marbles02/go/internal/memstub"
+)
+
+// agree runs agreeToSellMarble or agreeToBuyMarble with a price in the transient map
+func agree(l *testLedger, as, price, function string, args ...string) string {
+	response := l.ledger.Invoke(l.chaincode, memstub.Proposal{
+		Function:  function,
+		Args:      args,
+		Creator:   l.identity(as),
+		Transient: map[string][]byte{priceTransientKey: []byte(price)},
+	})
+	if response.Status != shim.OK {
+		return response.Message
+	}
+	return ""
+}
+
+func TestSellMarbleWithAgreedPrice(t *testing.T) {
+	const price = `{"price":100,"tradeId":"trade-42"}`
+	for _, test := range []struct {
+		name   string
+		seller string
+		marble string
+		want   string // error of agreeToSellMarble, or empty if the sale succeeds
+	}{
+		{name: "registrant", seller: "tom", marble: "marble1"},
+		{name: "non-registrant", seller: "mallory", marble: "marble1", want: "only the registrant of owner tom"},
+		{name: "not a marble", seller: "tom", marble: "car_vin1", want: "car_vin1 is not a marble"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := newTestLedger(t)
+			l.mustInvoke(roleAdmin, "registerAssetType", carAssetType)
+			l.mustInvoke("tom", "registerOwner", "tom", "Tom")
+			l.mustInvoke("tom", "initMarble", "marble1", "blue", "35", "tom")
+			l.mustInvoke("tom", "createAsset", "car", "vin1", `{"make":"fiat","year":2019,"owner":"tom"}`)
+
+			message := agree(l, test.seller, price, "agreeToSellMarble", test.marble)
+			if test.want != "" {
+				if !strings.Contains(message, test.want) {
+					t.Errorf("agreeToSellMarble failed with %q, expected %q", message, test.want)
+				}
+				return
+			} else if message != "" {
+				t.Fatalf("agreeToSellMarble failed: %s", message)
+			}
+			if message := agree(l, "jerry", price, "agreeToBuyMarble", test.marble, "jerry"); message != "" {
+				t.Fatalf("agreeToBuyMarble failed: %s", message)
+			}
+			l.mustFail("only the registrant of owner tom", "mallory", "sellMarbleWithAgreedPrice", test.marble, "jerry")
+			l.mustInvoke("tom", "sellMarbleWithAgreedPrice", test.marble, "jerry")
+			if marbleDoc := readTestMarble(l, test.marble); marbleDoc.Owner != "jerry" {
+				t.Errorf("%s is owned by %s, expected jerry", test.marble, marbleDoc.Owner)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Ownership provenance ====
+// getProvenance reduces the history of a marble to its ownership periods, oldest first.
+// Updates that did not change the owner are collapsed into the period they fall in, and a
+// period ends either with a transfer to the next owner or with the deletion of the marble.
+// A marble that was deleted and created again starts a new period. The key must currently
+// hold, or last have held, a marble; versions that are not marbles are skipped.
+//
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["getProvenance","marble1"]}'
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// ownershipPeriod is the time a marble spent with one owner
+type ownershipPeriod struct {
+	Owner    string `json:"owner"`
+	FromTxID string `json:"fromTxId"`
+	ToTxID   string `json:"toTxId,omitempty"` // empty while the period is current
+	Start    string `json:"start"`
+	End      string `json:"end,omitempty"`
+	Deleted  bool   `json:"deleted"` // the period ended with the deletion of the marble
+}
+
+// provenance is the ownership timeline of a marble
+type provenance struct {
+	Marble  string             `json:"marble"`
+	Periods []*ownershipPeriod `json:"periods"`
+}
+
+// modificationTime formats the timestamp of a history entry
+func modificationTime(modification *queryresult.KeyModification) string {
+	timestamp := modification.Timestamp
+	return time.Unix(timestamp.GetSeconds(), int64(timestamp.GetNanos())).UTC().Format(time.RFC3339Nano)
+}
+
+// ===========================================================================================
+// getProvenance - walk the history of a marble and return its ownership periods
+// ===========================================================================================
+func (t *SimpleChaincode) getProvenance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//     0
+	// "marble1"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	marbleName := args[0]
+	if len(marbleName) <= 0 {
+		return shim.Error("1st argument must be a non-empty string")
+	}
+	fmt.Printf("- start getProvenance: %s\n", marbleName)
+
+	resultsIterator, err := stub.GetHistoryForKey(marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	// The peer returns the newest modification first
+	var modifications []*queryresult.KeyModification
+	for resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		modifications = append(modifications, modification)
+	}
+	if len(modifications) == 0 {
+		return shim.Error("Marble " + marbleName + " has no history")
+	}
+	for _, modification := range modifications {
+		if modification.IsDelete {
+			continue
+		}
+		if !isMarbleDocument(modification.Value) {
+			return shim.Error("Not a marble: " + marbleName)
+		}
+		break
+	}
+
+	result := &provenance{Marble: marbleName, Periods: []*ownershipPeriod{}}
+	var current *ownershipPeriod
+	for i := len(modifications) - 1; i >= 0; i-- {
+		modification := modifications[i]
+		if modification.IsDelete {
+			if current != nil {
+				current.ToTxID = modification.TxId
+				current.End = modificationTime(modification)
+				current.Deleted = true
+				current = nil
+			}
+			continue
+		}
+		if !isMarbleDocument(modification.Value) {
+			continue
+		}
+
+		var marbleDoc marble
+		if err := json.Unmarshal(modification.Value, &marbleDoc); err != nil {
+			return shim.Error("Failed to decode marble " + marbleName + " at transaction " + modification.TxId + ": " + err.Error())
+		}
+		if current != nil && current.Owner == marbleDoc.Owner {
+			continue
+		}
+		if current != nil {
+			current.ToTxID = modification.TxId
+			current.End = modificationTime(modification)
+		}
+		current = &ownershipPeriod{
+			Owner:    marbleDoc.Owner,
+			FromTxID: modification.TxId,
+			Start:    modificationTime(modification),
+		}
+		result.Periods = append(result.Periods, current)
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- getProvenance returning %d periods\n", len(result.Periods))
+	return shim.Success(resultAsBytes)
+}
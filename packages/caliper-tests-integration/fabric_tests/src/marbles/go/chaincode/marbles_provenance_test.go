@@ -0,0 +1,86 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+
+package chaincode
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// readProvenance reads the ownership periods of a marble through getProvenance
+func readProvenance(l *testLedger, name string) []*ownershipPeriod {
+	l.t.Helper()
+	result := &provenance{}
+	if err := json.Unmarshal(l.mustInvoke("reader", "getProvenance", name), result); err != nil {
+		l.t.Fatal(err)
+	}
+	return result.Periods
+}
+
+func TestProvenancePeriods(t *testing.T) {
+	l := newTestLedger(t)
+	l.mustInvoke("tom", "initMarble", "marble1", "blue", "35", "tom")
+	l.mustInvoke("tom", "transferMarble", "marble1", "jerry")
+	l.mustInvoke("jerry", "updateAsset", "marble", "marble1", `{"size":40}`)
+	l.mustInvoke("jerry", "transferMarble", "marble1", "jerry")
+	l.mustInvoke("jerry", "delete", "marble1")
+	l.mustInvoke("tom", "initMarble", "marble1", "red", "35", "spike")
+
+	periods := readProvenance(l, "marble1")
+	expected := []ownershipPeriod{
+		{Owner: "tom"},
+		{Owner: "jerry", Deleted: true},
+		{Owner: "spike"},
+	}
+	if len(periods) != len(expected) {
+		t.Fatalf("got %d periods, expected %d", len(periods), len(expected))
+	}
+	for i, period := range periods {
+		if period.Owner != expected[i].Owner || period.Deleted != expected[i].Deleted {
+			t.Errorf("period %d: got owner %s deleted %t, expected owner %s deleted %t",
+				i, period.Owner, period.Deleted, expected[i].Owner, expected[i].Deleted)
+		}
+		if i > 0 && periods[i-1].ToTxID == "" {
+			t.Errorf("period %d has no end although period %d follows", i-1, i)
+		}
+	}
+	if last := periods[len(periods)-1]; last.ToTxID != "" || last.End != "" {
+		t.Errorf("the current period ends at %s", last.ToTxID)
+	}
+}
+
+func TestProvenanceOfOtherDocuments(t *testing.T) {
+	l := newTestLedger(t)
+	l.mustInvoke(roleAdmin, "registerAssetType", carAssetType)
+
+	// a marble name reused by an asset
+	l.mustInvoke("tom", "initMarble", "car_vin1", "blue", "35", "tom")
+	l.mustInvoke("tom", "delete", "car_vin1")
+	l.mustInvoke("tom", "createAsset", "car", "vin1", `{"make":"fiat","year":2019,"owner":"tom"}`)
+	l.mustFail("Not a marble: car_vin1", "reader", "getProvenance", "car_vin1")
+	l.mustInvoke("tom", "deleteAsset", "car", "vin1")
+	l.mustFail("Not a marble: car_vin1", "reader", "getProvenance", "car_vin1")
+
+	// an asset key reused by a marble, whose earlier versions are skipped
+	l.mustInvoke("tom", "initMarble", "car_vin1", "red", "50", "jerry")
+	periods := readProvenance(l, "car_vin1")
+	if len(periods) != 2 {
+		t.Fatalf("got %d periods, expected 2", len(periods))
+	}
+	if periods[0].Owner != "tom" || !periods[0].Deleted || periods[1].Owner != "jerry" {
+		t.Errorf("got %s (deleted %t) then %s, expected tom (deleted) then jerry", periods[0].Owner, periods[0].Deleted, periods[1].Owner)
+	}
+}
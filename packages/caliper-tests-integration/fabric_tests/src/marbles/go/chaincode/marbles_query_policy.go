@@ -0,0 +1,216 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Query policy (admin only) ====
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["setQueryPolicy","{\"forceDocType\":true,\"requireUseIndex\":true,\"maxLimit\":100,\"forbiddenOperators\":[\"$regex\",\"$or\"]}"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["getQueryPolicy"]}'
+
+package chaincode
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// queryPolicyConfig is the name of the configuration document holding the query policy
+const queryPolicyConfig = "queryPolicy"
+
+// queryPolicy restricts the ad hoc rich queries accepted by queryMarbles and
+// queryMarblesWithPagination. The zero value places no restriction on queries.
+type queryPolicy struct {
+	ForceDocType       bool     `json:"forceDocType"`       // restrict every query to docType "marble"
+	RequireUseIndex    bool     `json:"requireUseIndex"`    // reject queries without a use_index hint
+	MaxLimit           int32    `json:"maxLimit"`           // cap on limit and page size, 0 means no cap
+	ForbiddenOperators []string `json:"forbiddenOperators"` // selector operators that are rejected, e.g. "$regex"
+}
+
+// validate checks that the policy is well formed
+func (p *queryPolicy) validate() error {
+	if p.MaxLimit < 0 {
+		return fmt.Errorf("maxLimit must not be negative")
+	}
+	for _, operator := range p.ForbiddenOperators {
+		if !strings.HasPrefix(operator, "$") {
+			return fmt.Errorf("forbidden operator %q must start with $", operator)
+		}
+	}
+	return nil
+}
+
+// getQueryPolicy reads the query policy from state, returning the unrestricted zero value if
+// no policy has been set
+func getQueryPolicy(stub shim.ChaincodeStubInterface) (*queryPolicy, error) {
+	policy := &queryPolicy{}
+	if _, err := getConfig(stub, queryPolicyConfig, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// findForbiddenOperator walks a decoded query selector and returns the first forbidden
+// operator it contains
+func findForbiddenOperator(node interface{}, forbidden map[string]bool) (string, bool) {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		// visit keys in order so that every peer reports the same operator
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if forbidden[key] {
+				return key, true
+			}
+			if operator, found := findForbiddenOperator(value[key], forbidden); found {
+				return operator, true
+			}
+		}
+	case []interface{}:
+		for _, child := range value {
+			if operator, found := findForbiddenOperator(child, forbidden); found {
+				return operator, true
+			}
+		}
+	}
+	return "", false
+}
+
+// isZero reports whether the policy places no restriction on queries
+func (p *queryPolicy) isZero() bool {
+	return !p.ForceDocType && !p.RequireUseIndex && p.MaxLimit == 0 && len(p.ForbiddenOperators) == 0
+}
+
+// apply checks a client supplied query against the policy and returns the query to execute.
+// For paginated queries the page size is capped instead of the query's limit.
+func (p *queryPolicy) apply(queryString string, paginated bool, pageSize int32) (string, int32, error) {
+	if p.isZero() {
+		return queryString, pageSize, nil
+	}
+
+	var query map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(queryString))
+	decoder.UseNumber()
+	if err := decoder.Decode(&query); err != nil || query == nil {
+		return "", 0, fmt.Errorf("query string must be a JSON object")
+	}
+	selector, ok := query["selector"].(map[string]interface{})
+	if !ok {
+		return "", 0, fmt.Errorf("query must contain a selector object")
+	}
+
+	if len(p.ForbiddenOperators) > 0 {
+		forbidden := make(map[string]bool, len(p.ForbiddenOperators))
+		for _, operator := range p.ForbiddenOperators {
+			forbidden[operator] = true
+		}
+		if operator, found := findForbiddenOperator(query, forbidden); found {
+			return "", 0, fmt.Errorf("query uses forbidden operator %s", operator)
+		}
+	}
+
+	if p.RequireUseIndex {
+		switch index := query["use_index"].(type) {
+		case string:
+			if len(index) <= 0 {
+				return "", 0, fmt.Errorf("query must specify use_index")
+			}
+		case []interface{}:
+			if len(index) <= 0 {
+				return "", 0, fmt.Errorf("query must specify use_index")
+			}
+		default:
+			return "", 0, fmt.Errorf("query must specify use_index")
+		}
+	}
+
+	if p.ForceDocType {
+		// top-level selector fields are implicitly combined with $and
+		selector["docType"] = "marble"
+	}
+
+	if p.MaxLimit > 0 && paginated {
+		if pageSize <= 0 || pageSize > p.MaxLimit {
+			pageSize = p.MaxLimit
+		}
+	} else if p.MaxLimit > 0 {
+		limit := int64(p.MaxLimit)
+		if value, ok := query["limit"].(json.Number); ok {
+			if requested, err := value.Int64(); err == nil && requested > 0 && requested < limit {
+				limit = requested
+			}
+		}
+		query["limit"] = limit
+	}
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(query); err != nil {
+		return "", 0, err
+	}
+	return strings.TrimSpace(buffer.String()), pageSize, nil
+}
+
+// ==========================================================================
+// setQueryPolicy - store the policy applied to ad hoc rich queries
+// ==========================================================================
+func (t *SimpleChaincode) setQueryPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "{\"forceDocType\":true}"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	policy := &queryPolicy{}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(policy); err != nil {
+		return shim.Error("Invalid query policy: " + err.Error())
+	}
+	if err := policy.validate(); err != nil {
+		return shim.Error("Invalid query policy: " + err.Error())
+	}
+
+	if err := putConfig(stub, queryPolicyConfig, policy); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ==========================================================================
+// getQueryPolicy - read the policy applied to ad hoc rich queries
+// ==========================================================================
+func (t *SimpleChaincode) getQueryPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	policy, err := getQueryPolicy(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	policyAsBytes, err := json.Marshal(policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(policyAsBytes)
+}
@@ -0,0 +1,352 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Named query templates (register and delete are admin only) ====
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["registerQueryTemplate","byOwnerAndMinSize","{\"selector\":{\"docType\":\"marble\",\"owner\":\"${owner}\",\"size\":{\"$gte\":\"${minSize}\"}}}","{\"owner\":\"string\",\"minSize\":\"integer\"}"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["runNamedQuery","byOwnerAndMinSize","{\"owner\":\"tom\",\"minSize\":10}","10",""]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["deleteQueryTemplate","byOwnerAndMinSize"]}'
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// queryTemplateObjectType is the composite key object type of stored query templates
+const queryTemplateObjectType = "queryTemplate"
+
+// Parameter types accepted in a query template parameter schema
+const (
+	paramTypeString      = "string"
+	paramTypeNumber      = "number"
+	paramTypeInteger     = "integer"
+	paramTypeBoolean     = "boolean"
+	paramTypeStringArray = "string[]"
+	paramTypeNumberArray = "number[]"
+)
+
+var queryTemplateParamTypes = map[string]bool{
+	paramTypeString:      true,
+	paramTypeNumber:      true,
+	paramTypeInteger:     true,
+	paramTypeBoolean:     true,
+	paramTypeStringArray: true,
+	paramTypeNumberArray: true,
+}
+
+// queryTemplate is a Mango query stored on the ledger. A JSON string value of the form
+// "${name}" anywhere in the template is a placeholder that runNamedQuery replaces with the
+// typed value of the parameter "name".
+type queryTemplate struct {
+	ObjectType  string            `json:"docType"`
+	Name        string            `json:"name"`
+	Template    json.RawMessage   `json:"template"`
+	ParamSchema map[string]string `json:"paramSchema"`
+}
+
+// placeholderName returns the parameter name if s is a "${name}" placeholder
+func placeholderName(s string) (string, bool) {
+	if strings.HasPrefix(s, "${") && strings.HasSuffix(s, "}") && len(s) > 3 {
+		return s[2 : len(s)-1], true
+	}
+	return "", false
+}
+
+// collectPlaceholders returns every placeholder name used in a decoded template
+func collectPlaceholders(node interface{}, names map[string]bool) {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		for _, child := range value {
+			collectPlaceholders(child, names)
+		}
+	case []interface{}:
+		for _, child := range value {
+			collectPlaceholders(child, names)
+		}
+	case string:
+		if name, ok := placeholderName(value); ok {
+			names[name] = true
+		}
+	}
+}
+
+// substitutePlaceholders returns a copy of a decoded template with every placeholder replaced
+func substitutePlaceholders(node interface{}, params map[string]interface{}) interface{} {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		substituted := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			substituted[key] = substitutePlaceholders(child, params)
+		}
+		return substituted
+	case []interface{}:
+		substituted := make([]interface{}, len(value))
+		for i, child := range value {
+			substituted[i] = substitutePlaceholders(child, params)
+		}
+		return substituted
+	case string:
+		if name, ok := placeholderName(value); ok {
+			return params[name]
+		}
+	}
+	return node
+}
+
+// decodeJSONObject decodes a JSON object, keeping numbers in their original representation
+func decodeJSONObject(s string) (map[string]interface{}, error) {
+	var object map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+	if object == nil || decoder.More() {
+		return nil, fmt.Errorf("expecting a JSON object")
+	}
+	return object, nil
+}
+
+// checkParamType checks that a decoded parameter value matches its declared type
+func checkParamType(name, paramType string, value interface{}) error {
+	switch paramType {
+	case paramTypeString:
+		if _, ok := value.(string); ok {
+			return nil
+		}
+	case paramTypeNumber:
+		if _, ok := value.(json.Number); ok {
+			return nil
+		}
+	case paramTypeInteger:
+		if number, ok := value.(json.Number); ok {
+			if _, err := strconv.ParseInt(number.String(), 10, 64); err == nil {
+				return nil
+			}
+		}
+	case paramTypeBoolean:
+		if _, ok := value.(bool); ok {
+			return nil
+		}
+	case paramTypeStringArray, paramTypeNumberArray:
+		elements, ok := value.([]interface{})
+		if !ok {
+			break
+		}
+		elementType := strings.TrimSuffix(paramType, "[]")
+		for _, element := range elements {
+			if err := checkParamType(name, elementType, element); err != nil {
+				return fmt.Errorf("parameter %s must be of type %s", name, paramType)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("parameter %s must be of type %s", name, paramType)
+}
+
+// sortedParamNames returns the parameter names of a schema in a deterministic order
+func sortedParamNames(paramSchema map[string]string) []string {
+	names := make([]string, 0, len(paramSchema))
+	for name := range paramSchema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getQueryTemplate reads a stored query template, returning nil if it does not exist
+func getQueryTemplate(stub shim.ChaincodeStubInterface, name string) (*queryTemplate, error) {
+	templateKey, err := stub.CreateCompositeKey(queryTemplateObjectType, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	templateAsBytes, err := stub.GetState(templateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get query template %s: %s", name, err.Error())
+	} else if templateAsBytes == nil {
+		return nil, nil
+	}
+	template := &queryTemplate{}
+	if err := json.Unmarshal(templateAsBytes, template); err != nil {
+		return nil, fmt.Errorf("failed to decode query template %s: %s", name, err.Error())
+	}
+	return template, nil
+}
+
+// ==========================================================================================
+// registerQueryTemplate - store (or replace) a named Mango query template and its parameter
+// schema. Every placeholder used by the template must be declared in the schema.
+// ==========================================================================================
+func (t *SimpleChaincode) registerQueryTemplate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//      0                  1                                       2
+	// "byOwner", "{\"selector\":{\"owner\":\"${owner}\"}}", "{\"owner\":\"string\"}"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	name := args[0]
+	if len(name) <= 0 {
+		return shim.Error("1st argument must be a non-empty string")
+	}
+	template, err := decodeJSONObject(args[1])
+	if err != nil {
+		return shim.Error("2nd argument must be a JSON object: " + err.Error())
+	}
+	if _, ok := template["selector"].(map[string]interface{}); !ok {
+		return shim.Error("2nd argument must contain a selector object")
+	}
+	paramSchema := map[string]string{}
+	if err := json.Unmarshal([]byte(args[2]), &paramSchema); err != nil {
+		return shim.Error("3rd argument must be a JSON object of parameter name to type: " + err.Error())
+	}
+	for _, param := range sortedParamNames(paramSchema) {
+		if !queryTemplateParamTypes[paramSchema[param]] {
+			return shim.Error(fmt.Sprintf("Unsupported type %q for parameter %s", paramSchema[param], param))
+		}
+	}
+
+	placeholders := map[string]bool{}
+	collectPlaceholders(template, placeholders)
+	undeclared := []string{}
+	for placeholder := range placeholders {
+		if _, ok := paramSchema[placeholder]; !ok {
+			undeclared = append(undeclared, placeholder)
+		}
+	}
+	if len(undeclared) > 0 {
+		sort.Strings(undeclared)
+		return shim.Error("Template uses undeclared parameters: " + strings.Join(undeclared, ", "))
+	}
+
+	templateKey, err := stub.CreateCompositeKey(queryTemplateObjectType, []string{name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	templateJSONasBytes, err := json.Marshal(&queryTemplate{queryTemplateObjectType, name, json.RawMessage(args[1]), paramSchema})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(templateKey, templateJSONasBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ==================================================
+// deleteQueryTemplate - remove a named query template
+// ==================================================
+func (t *SimpleChaincode) deleteQueryTemplate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	template, err := getQueryTemplate(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if template == nil {
+		return shim.Error("Query template does not exist: " + args[0])
+	}
+
+	templateKey, err := stub.CreateCompositeKey(queryTemplateObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.DelState(templateKey); err != nil {
+		return shim.Error("Failed to delete state:" + err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ==========================================================================================
+// runNamedQuery executes a stored query template with the given parameters. Parameter values
+// are type checked against the template's schema and substituted as JSON values, so they
+// cannot change the structure of the query.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// ==========================================================================================
+func (t *SimpleChaincode) runNamedQuery(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//      0                    1                  2    3
+	// "byOwner", "{\"owner\":\"tom\"}", "10", ""
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	template, err := getQueryTemplate(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if template == nil {
+		return shim.Error("Query template does not exist: " + args[0])
+	}
+
+	params := map[string]interface{}{}
+	if len(args[1]) > 0 {
+		params, err = decodeJSONObject(args[1])
+		if err != nil {
+			return shim.Error("2nd argument must be a JSON object: " + err.Error())
+		}
+	}
+	for param := range params {
+		if _, ok := template.ParamSchema[param]; !ok {
+			return shim.Error("Unknown parameter: " + param)
+		}
+	}
+	for _, param := range sortedParamNames(template.ParamSchema) {
+		value, ok := params[param]
+		if !ok {
+			return shim.Error("Missing parameter: " + param)
+		}
+		if err := checkParamType(param, template.ParamSchema[param], value); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	pageSize := int64(0)
+	if len(args[2]) > 0 {
+		//return type of ParseInt is int64
+		pageSize, err = strconv.ParseInt(args[2], 10, 32)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	bookmark := args[3]
+
+	decodedTemplate, err := decodeJSONObject(string(template.Template))
+	if err != nil {
+		return shim.Error("Failed to decode query template: " + err.Error())
+	}
+	queryString, err := json.Marshal(substitutePlaceholders(decodedTemplate, params))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("- runNamedQuery %s queryString:\n%s\n", template.Name, queryString)
+
+	result, err := executeRichQuery(stub, string(queryString), int32(pageSize), bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,336 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Holding quotas and minting rate limits (setQuotaPolicy is admin only) ====
+// While holdings are tracked, every function that creates, transfers or deletes marbles updates
+// a counter per owner, and initMarble and transferMarble reject changes that would take an
+// owner over maxHoldings. Enable tracking before the marbles are created: marbles that exist
+// when tracking starts are not counted. Mints are counted per invoking identity in windows of
+// mintWindowSeconds of the transaction timestamp.
+//
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["setQuotaPolicy","{\"trackHoldings\":true,\"maxHoldings\":100,\"maxMintsPerWindow\":10,\"mintWindowSeconds\":60}"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["getOwnerQuota","tom"]}'
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	// quotaPolicyConfig is the configuration document holding the quota policy
+	quotaPolicyConfig = "quotaPolicy"
+
+	// holdingsObjectType and mintsObjectType are the docTypes and composite key object types
+	// of the per-owner holdings counters and the per-identity mint counters
+	holdingsObjectType = "holdings"
+	mintsObjectType    = "mints"
+)
+
+// quotaPolicy limits marble holdings and minting. Zero limits are unlimited.
+type quotaPolicy struct {
+	TrackHoldings     bool  `json:"trackHoldings"` // count holdings even without a limit
+	MaxHoldings       int   `json:"maxHoldings"`
+	MaxMintsPerWindow int   `json:"maxMintsPerWindow"`
+	MintWindowSeconds int64 `json:"mintWindowSeconds"`
+}
+
+// tracksHoldings reports whether the holdings counters are maintained
+func (p *quotaPolicy) tracksHoldings() bool {
+	return p.TrackHoldings || p.MaxHoldings > 0
+}
+
+// quotaCounter is a holdings or mint counter document
+type quotaCounter struct {
+	ObjectType string `json:"docType"`
+	Owner      string `json:"owner,omitempty"`  // for holdings
+	Window     string `json:"window,omitempty"` // start of the mint window, RFC 3339
+	Count      int    `json:"count"`
+}
+
+// ownerQuota is the response of getOwnerQuota. The mint fields describe the invoking identity.
+type ownerQuota struct {
+	Owner             string `json:"owner"`
+	TrackingHoldings  bool   `json:"trackingHoldings"`
+	Holdings          int    `json:"holdings"`
+	MaxHoldings       int    `json:"maxHoldings"`
+	MintWindow        string `json:"mintWindow,omitempty"`
+	Mints             int    `json:"mints"`
+	MaxMintsPerWindow int    `json:"maxMintsPerWindow"`
+}
+
+// quotaStub caches the quota policy and the counters written during an invocation. Fabric does
+// not let a transaction read its own writes, so functions that re-use initMarble or
+// transferMarble for many marbles, such as seedMarbles, would otherwise lose all but the last
+// update of a counter.
+type quotaStub struct {
+	shim.ChaincodeStubInterface
+	policy   *quotaPolicy
+	counters map[string]*quotaCounter
+}
+
+func newQuotaStub(stub shim.ChaincodeStubInterface) *quotaStub {
+	return &quotaStub{ChaincodeStubInterface: stub, counters: map[string]*quotaCounter{}}
+}
+
+// loadQuotaPolicy reads the quota policy, once per invocation
+func loadQuotaPolicy(stub shim.ChaincodeStubInterface) (*quotaPolicy, error) {
+	cache, cached := stub.(*quotaStub)
+	if cached && cache.policy != nil {
+		return cache.policy, nil
+	}
+	policy := &quotaPolicy{}
+	if _, err := getConfig(stub, quotaPolicyConfig, policy); err != nil {
+		return nil, err
+	}
+	if cached {
+		cache.policy = policy
+	}
+	return policy, nil
+}
+
+// getQuotaCounter reads a counter, or returns a zero counter if it has never been written
+func getQuotaCounter(stub shim.ChaincodeStubInterface, objectType string, attributes []string) (string, *quotaCounter, error) {
+	counterKey, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return "", nil, err
+	}
+	if cache, ok := stub.(*quotaStub); ok {
+		if counter, ok := cache.counters[counterKey]; ok {
+			copied := *counter
+			return counterKey, &copied, nil
+		}
+	}
+	counter := &quotaCounter{ObjectType: objectType}
+	counterAsBytes, err := stub.GetState(counterKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get %s counter: %s", objectType, err.Error())
+	} else if counterAsBytes != nil {
+		if err := json.Unmarshal(counterAsBytes, counter); err != nil {
+			return "", nil, err
+		}
+	}
+	return counterKey, counter, nil
+}
+
+// putQuotaCounter stores a counter and keeps it for later reads of the invocation
+func putQuotaCounter(stub shim.ChaincodeStubInterface, counterKey string, counter *quotaCounter) error {
+	counterAsBytes, err := json.Marshal(counter)
+	if err != nil {
+		return err
+	}
+	if err := stub.PutState(counterKey, counterAsBytes); err != nil {
+		return err
+	}
+	if cache, ok := stub.(*quotaStub); ok {
+		copied := *counter
+		cache.counters[counterKey] = &copied
+	}
+	return nil
+}
+
+// adjustHoldings applies changes to the holdings of owners, failing if an owner that gains
+// marbles would exceed the quota. Owners are updated in order, so write sets are deterministic.
+func adjustHoldings(stub shim.ChaincodeStubInterface, deltas map[string]int) error {
+	policy, err := loadQuotaPolicy(stub)
+	if err != nil {
+		return err
+	}
+	if !policy.tracksHoldings() {
+		return nil
+	}
+
+	owners := make([]string, 0, len(deltas))
+	for owner, delta := range deltas {
+		if delta != 0 {
+			owners = append(owners, owner)
+		}
+	}
+	sort.Strings(owners)
+	for _, owner := range owners {
+		counterKey, counter, err := getQuotaCounter(stub, holdingsObjectType, []string{owner})
+		if err != nil {
+			return err
+		}
+		counter.Owner = owner
+		counter.Count += deltas[owner]
+		if counter.Count < 0 {
+			// the owner held marbles from before tracking started
+			counter.Count = 0
+		}
+		if deltas[owner] > 0 && policy.MaxHoldings > 0 && counter.Count > policy.MaxHoldings {
+			return fmt.Errorf("owner %s would hold %d marbles, over the quota of %d", owner, counter.Count, policy.MaxHoldings)
+		}
+		if err := putQuotaCounter(stub, counterKey, counter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveHoldings records the transfer of a marble between owners
+func moveHoldings(stub shim.ChaincodeStubInterface, from, to string) error {
+	if from == to {
+		return nil
+	}
+	return adjustHoldings(stub, map[string]int{from: -1, to: 1})
+}
+
+// mintWindow returns the start of the mint window holding the transaction timestamp
+func mintWindow(stub shim.ChaincodeStubInterface, policy *quotaPolicy) (int64, error) {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get transaction timestamp: %s", err.Error())
+	}
+	return timestamp.Seconds - timestamp.Seconds%policy.MintWindowSeconds, nil
+}
+
+// recordMint counts a marble minted by the invoking identity, failing if the identity has
+// reached its limit for the current window
+func recordMint(stub shim.ChaincodeStubInterface) error {
+	policy, err := loadQuotaPolicy(stub)
+	if err != nil {
+		return err
+	}
+	if policy.MaxMintsPerWindow <= 0 {
+		return nil
+	}
+	invoker, err := cid.GetID(stub)
+	if err != nil {
+		return fmt.Errorf("failed to identify the invoker: %s", err.Error())
+	}
+	window, err := mintWindow(stub, policy)
+	if err != nil {
+		return err
+	}
+
+	counterKey, counter, err := getQuotaCounter(stub, mintsObjectType, []string{invoker, strconv.FormatInt(window, 10)})
+	if err != nil {
+		return err
+	}
+	if counter.Count >= policy.MaxMintsPerWindow {
+		return fmt.Errorf("the invoker has reached the limit of %d mints per %d second window", policy.MaxMintsPerWindow, policy.MintWindowSeconds)
+	}
+	counter.Window = time.Unix(window, 0).UTC().Format(time.RFC3339)
+	counter.Count++
+	return putQuotaCounter(stub, counterKey, counter)
+}
+
+// ==========================================================================================
+// setQuotaPolicy - store the holding quota and minting rate limit
+// ==========================================================================================
+func (t *SimpleChaincode) setQuotaPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "{\"trackHoldings\":true,\"maxHoldings\":100,\"maxMintsPerWindow\":10,\"mintWindowSeconds\":60}"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	policy := &quotaPolicy{}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(policy); err != nil {
+		return shim.Error("Invalid quota policy: " + err.Error())
+	}
+	if policy.MaxHoldings < 0 || policy.MaxMintsPerWindow < 0 || policy.MintWindowSeconds < 0 {
+		return shim.Error("Invalid quota policy: limits must not be negative")
+	}
+	if policy.MaxMintsPerWindow > 0 && policy.MintWindowSeconds == 0 {
+		return shim.Error("Invalid quota policy: mintWindowSeconds must be positive to limit mints")
+	}
+
+	if err := putConfig(stub, quotaPolicyConfig, policy); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ==========================================================================================
+// getQuotaPolicy - read the holding quota and minting rate limit
+// ==========================================================================================
+func (t *SimpleChaincode) getQuotaPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	policy, err := loadQuotaPolicy(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	policyAsBytes, err := json.Marshal(policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(policyAsBytes)
+}
+
+// ==========================================================================================
+// getOwnerQuota - read the holdings of an owner, and the mints of the invoking identity in
+// the current window, against their limits
+// ==========================================================================================
+func (t *SimpleChaincode) getOwnerQuota(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the owner to query")
+	}
+	owner := strings.ToLower(args[0])
+
+	policy, err := loadQuotaPolicy(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	quota := &ownerQuota{
+		Owner:             owner,
+		TrackingHoldings:  policy.tracksHoldings(),
+		MaxHoldings:       policy.MaxHoldings,
+		MaxMintsPerWindow: policy.MaxMintsPerWindow,
+	}
+	_, holdings, err := getQuotaCounter(stub, holdingsObjectType, []string{owner})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	quota.Holdings = holdings.Count
+
+	if policy.MintWindowSeconds > 0 {
+		invoker, err := cid.GetID(stub)
+		if err != nil {
+			return shim.Error("Failed to identify the invoker: " + err.Error())
+		}
+		window, err := mintWindow(stub, policy)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, mints, err := getQuotaCounter(stub, mintsObjectType, []string{invoker, strconv.FormatInt(window, 10)})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		quota.MintWindow = time.Unix(window, 0).UTC().Format(time.RFC3339)
+		quota.Mints = mints.Count
+	}
+
+	quotaAsBytes, err := json.Marshal(quota)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(quotaAsBytes)
+}
@@ -0,0 +1,77 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+
+
+package chaincode
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHoldingQuota(t *testing.T) {
+	l := newTestLedger(t)
+	l.mustInvoke(roleAdmin, "setQuotaPolicy", `{"maxHoldings":2}`)
+	l.mustInvoke("tom", "registerOwner", "tom", "Tom")
+	l.mustInvoke("tom", "initMarble", "marble1", "blue", "35", "tom")
+	l.mustInvoke("tom", "initMarble", "marble2", "blue", "35", "tom")
+	l.mustInvoke("jerry", "initMarble", "marble3", "red", "50", "jerry")
+
+	l.mustFail("owner tom would hold 3 marbles, over the quota of 2", "tom", "initMarble", "marble4", "blue", "35", "tom")
+	l.mustFail("owner tom would hold 3 marbles, over the quota of 2", "jerry", "transferMarble", "marble3", "tom")
+
+	// deleting a marble frees a place
+	l.mustInvoke("tom", "delete", "marble1")
+	l.mustInvoke("jerry", "transferMarble", "marble3", "tom")
+
+	quota := &ownerQuota{}
+	if err := json.Unmarshal(l.mustInvoke("reader", "getOwnerQuota", "tom"), quota); err != nil {
+		t.Fatal(err)
+	}
+	if quota.Holdings != 2 || quota.MaxHoldings != 2 {
+		t.Errorf("tom holds %d of %d marbles, want 2 of 2", quota.Holdings, quota.MaxHoldings)
+	}
+}
+
+func TestMintWindow(t *testing.T) {
+	// windows start at multiples of mintWindowSeconds of the transaction timestamp
+	windowStart := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name   string
+		as     string
+		offset time.Duration // after windowStart
+		want   string        // error, or empty if the mint succeeds
+	}{
+		{name: "end of window", as: "tom", offset: 59 * time.Second, want: "the invoker has reached the limit of 2 mints per 60 second window"},
+		{name: "other identity", as: "jerry", offset: 59 * time.Second},
+		{name: "next window", as: "tom", offset: 60 * time.Second},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := newTestLedger(t)
+			l.mustInvoke(roleAdmin, "setQuotaPolicy", `{"maxMintsPerWindow":2,"mintWindowSeconds":60}`)
+			l.now = windowStart
+			l.mustInvoke("tom", "initMarble", "marble1", "blue", "35", "tom")
+			l.mustInvoke("tom", "initMarble", "marble2", "blue", "35", "tom")
+
+			l.now = windowStart.Add(test.offset)
+			if test.want != "" {
+				l.mustFail(test.want, test.as, "initMarble", "marble3", "blue", "35", test.as)
+				return
+			}
+			l.mustInvoke(test.as, "initMarble", "marble3", "blue", "35", test.as)
+		})
+	}
+}
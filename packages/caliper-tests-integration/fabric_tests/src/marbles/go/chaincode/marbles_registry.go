@@ -0,0 +1,718 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Contract metadata ====
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["getMetadata"]}'
+
+package chaincode
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// contractName is the name reported by getMetadata
+const contractName = "marbles"
+
+// Argument types reported in the function metadata
+const (
+	argTypeString  = "string"
+	argTypeInteger = "integer"
+	argTypeJSON    = "json"
+)
+
+// argSpec describes a positional function argument
+type argSpec struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Optional    bool   `json:"optional,omitempty"`
+}
+
+// functionHandler implements a chaincode function
+type functionHandler func(t *SimpleChaincode, stub shim.ChaincodeStubInterface, args []string) pb.Response
+
+// functionSpec describes a chaincode function and the handler implementing it
+type functionSpec struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Args        []argSpec `json:"args"`
+	Transient   []string  `json:"transient,omitempty"` // transient map keys read by the function
+	ReadOnly    bool      `json:"readOnly"`
+	Roles       []string  `json:"roles,omitempty"` // the invoker must hold one of these roles
+	handler     functionHandler
+}
+
+// contractMetadata is the response of getMetadata
+type contractMetadata struct {
+	Name      string          `json:"name"`
+	Transient []string        `json:"transient"` // transient map keys accepted by every function
+	Functions []*functionSpec `json:"functions"`
+}
+
+// functionRegistry holds every function that Invoke dispatches to, in registration order
+var functionRegistry []*functionSpec
+
+// functionsByName indexes functionRegistry by function name
+var functionsByName = map[string]*functionSpec{}
+
+// registerFunctions adds functions to the registry
+func registerFunctions(specs ...*functionSpec) {
+	for _, spec := range specs {
+		if _, exists := functionsByName[spec.Name]; exists {
+			panic("function registered twice: " + spec.Name)
+		}
+		if spec.Args == nil {
+			spec.Args = []argSpec{}
+		}
+		functionRegistry = append(functionRegistry, spec)
+		functionsByName[spec.Name] = spec
+	}
+}
+
+// lookupFunction returns the specification of a registered function
+func lookupFunction(name string) (*functionSpec, bool) {
+	spec, ok := functionsByName[name]
+	return spec, ok
+}
+
+func init() {
+	registerFunctions(
+		&functionSpec{
+			Name:        "initMarble",
+			Description: "Create a new marble and index it by color",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+				{Name: "color", Type: argTypeString},
+				{Name: "size", Type: argTypeInteger},
+				{Name: "owner", Type: argTypeString},
+			},
+			handler: (*SimpleChaincode).initMarble,
+		},
+		&functionSpec{
+			Name:        "transferMarble",
+			Description: "Change the owner of a marble",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+				{Name: "newOwner", Type: argTypeString},
+			},
+			handler: (*SimpleChaincode).transferMarble,
+		},
+		&functionSpec{
+			Name:        "transferMarblesBasedOnColor",
+			Description: "Transfer every marble of a color to a new owner, using the color~name index",
+			Args: []argSpec{
+				{Name: "color", Type: argTypeString},
+				{Name: "newOwner", Type: argTypeString},
+				{Name: "mode", Type: argTypeString, Description: "reject (default) or skip locked marbles", Optional: true},
+			},
+			handler: (*SimpleChaincode).transferMarblesBasedOnColor,
+		},
+		&functionSpec{
+			Name:        "delete",
+			Description: "Delete a marble and its color~name index entry",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+			},
+			handler: (*SimpleChaincode).delete,
+		},
+		&functionSpec{
+			Name:        "readMarble",
+			Description: "Read a marble",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).readMarble,
+		},
+		&functionSpec{
+			Name:        "readMarbles",
+			Description: "Read a set of marbles, returning the marbles found and the names missing",
+			Args: []argSpec{
+				{Name: "names", Type: argTypeJSON, Description: "JSON array of marble names"},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).readMarbles,
+		},
+		&functionSpec{
+			Name:        "queryMarblesByOwner",
+			Description: "Find the marbles of an owner using a rich query (CouchDB only)",
+			Args: []argSpec{
+				{Name: "owner", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).queryMarblesByOwner,
+		},
+		&functionSpec{
+			Name:        "queryMarbles",
+			Description: "Run an ad hoc rich query, subject to the query policy (CouchDB only)",
+			Args: []argSpec{
+				{Name: "queryString", Type: argTypeJSON, Description: "Mango query"},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).queryMarbles,
+		},
+		&functionSpec{
+			Name:        "getHistoryForMarble",
+			Description: "Read every historic value of a marble",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).getHistoryForMarble,
+		},
+		&functionSpec{
+			Name:        "getProvenance",
+			Description: "Read the ownership periods of a marble, derived from its history",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).getProvenance,
+		},
+		&functionSpec{
+			Name:        "getMarblesByRange",
+			Description: "Read the marbles in a key range",
+			Args: []argSpec{
+				{Name: "startKey", Type: argTypeString},
+				{Name: "endKey", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).getMarblesByRange,
+		},
+		&functionSpec{
+			Name:        "computeStateDigest",
+			Description: "Hash the marbles in a key range to compare the world state of peers",
+			Args: []argSpec{
+				{Name: "startKey", Type: argTypeString},
+				{Name: "endKey", Type: argTypeString},
+				{Name: "includeIndex", Type: argTypeString, Description: "true to also hash the color~name index entries", Optional: true},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).computeStateDigest,
+		},
+		&functionSpec{
+			Name:        "exportMarbles",
+			Description: "Export a page of the marbles in a key range as newline-delimited JSON",
+			Args: []argSpec{
+				{Name: "startKey", Type: argTypeString},
+				{Name: "endKey", Type: argTypeString},
+				{Name: "pageSize", Type: argTypeInteger},
+				{Name: "bookmark", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).exportMarbles,
+		},
+		&functionSpec{
+			Name:        "importMarbles",
+			Description: "Create the marbles of a newline-delimited JSON export, with their indexes",
+			Args: []argSpec{
+				{Name: "ndjson", Type: argTypeString, Description: "one marble document per line"},
+				{Name: "policy", Type: argTypeString, Description: "skip (default) or overwrite existing marbles", Optional: true},
+			},
+			Roles:   []string{roleAdmin},
+			handler: (*SimpleChaincode).importMarbles,
+		},
+		&functionSpec{
+			Name:        "getMarblesByRangeWithPagination",
+			Description: "Read a page of the marbles in a key range",
+			Args: []argSpec{
+				{Name: "startKey", Type: argTypeString},
+				{Name: "endKey", Type: argTypeString},
+				{Name: "pageSize", Type: argTypeInteger},
+				{Name: "bookmark", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).getMarblesByRangeWithPagination,
+		},
+		&functionSpec{
+			Name:        "queryMarblesWithPagination",
+			Description: "Run a page of an ad hoc rich query, subject to the query policy (CouchDB only)",
+			Args: []argSpec{
+				{Name: "queryString", Type: argTypeJSON, Description: "Mango query"},
+				{Name: "pageSize", Type: argTypeInteger},
+				{Name: "bookmark", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).queryMarblesWithPagination,
+		},
+		&functionSpec{
+			Name:        "searchMarbles",
+			Description: "Find marbles matching a typed filter using rich query (CouchDB only)",
+			Args: []argSpec{
+				{Name: "filter", Type: argTypeJSON, Description: "owner, colors, minSize, maxSize, sortBy, sortDirection, pageSize, bookmark"},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).searchMarbles,
+		},
+		&functionSpec{
+			Name:        "seedMarbles",
+			Description: "Create a reproducible set of marbles derived from a seed",
+			Args: []argSpec{
+				{Name: "prefix", Type: argTypeString},
+				{Name: "count", Type: argTypeInteger},
+				{Name: "seed", Type: argTypeString},
+				{Name: "colorDist", Type: argTypeJSON, Description: "JSON object of color to weight, empty for the default colors"},
+				{Name: "ownerDist", Type: argTypeJSON, Description: "JSON object of owner to weight, empty for the default owners"},
+			},
+			handler: (*SimpleChaincode).seedMarbles,
+		},
+		&functionSpec{
+			Name:        "agreeToSellMarble",
+			Description: "Store the seller's agreed price in the seller's implicit private data collection",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+			},
+			Transient: []string{priceTransientKey},
+			handler:   (*SimpleChaincode).agreeToSellMarble,
+		},
+		&functionSpec{
+			Name:        "agreeToBuyMarble",
+			Description: "Store the buyer's agreed price in the buyer's implicit private data collection",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+				{Name: "buyer", Type: argTypeString},
+			},
+			Transient: []string{priceTransientKey},
+			handler:   (*SimpleChaincode).agreeToBuyMarble,
+		},
+		&functionSpec{
+			Name:        "sellMarbleWithAgreedPrice",
+			Description: "Transfer a marble to its buyer once the private agreed price hashes match",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+				{Name: "buyer", Type: argTypeString},
+			},
+			handler: (*SimpleChaincode).sellMarbleWithAgreedPrice,
+		},
+		&functionSpec{
+			Name:        "setQueryPolicy",
+			Description: "Store the policy applied to ad hoc rich queries",
+			Args: []argSpec{
+				{Name: "policy", Type: argTypeJSON, Description: "forceDocType, requireUseIndex, maxLimit, forbiddenOperators"},
+			},
+			Roles:   []string{roleAdmin},
+			handler: (*SimpleChaincode).setQueryPolicy,
+		},
+		&functionSpec{
+			Name:        "getQueryPolicy",
+			Description: "Read the policy applied to ad hoc rich queries",
+			ReadOnly:    true,
+			handler:     (*SimpleChaincode).getQueryPolicy,
+		},
+		&functionSpec{
+			Name:        "registerQueryTemplate",
+			Description: "Store a named rich query template and its parameter schema",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+				{Name: "mangoTemplate", Type: argTypeJSON, Description: "Mango query with \"${param}\" placeholders"},
+				{Name: "paramSchema", Type: argTypeJSON, Description: "JSON object of parameter name to type"},
+			},
+			Roles:   []string{roleAdmin},
+			handler: (*SimpleChaincode).registerQueryTemplate,
+		},
+		&functionSpec{
+			Name:        "deleteQueryTemplate",
+			Description: "Remove a named rich query template",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+			},
+			Roles:   []string{roleAdmin},
+			handler: (*SimpleChaincode).deleteQueryTemplate,
+		},
+		&functionSpec{
+			Name:        "runNamedQuery",
+			Description: "Run a stored rich query template with typed parameters (CouchDB only)",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+				{Name: "params", Type: argTypeJSON},
+				{Name: "pageSize", Type: argTypeInteger, Description: "0 or empty for an unpaginated query"},
+				{Name: "bookmark", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).runNamedQuery,
+		},
+		&functionSpec{
+			Name:        "registerOwner",
+			Description: "Register a marble owner; the invoker may later update its profile",
+			Args: []argSpec{
+				{Name: "id", Type: argTypeString, Description: "lower-cased name used as marble owner"},
+				{Name: "displayName", Type: argTypeString},
+				{Name: "profile", Type: argTypeJSON, Description: "object of string values, or empty", Optional: true},
+			},
+			handler: (*SimpleChaincode).registerOwner,
+		},
+		&functionSpec{
+			Name:        "updateOwnerProfile",
+			Description: "Replace the display name and, if given, the profile of an owner (registrant or admin only)",
+			Args: []argSpec{
+				{Name: "id", Type: argTypeString},
+				{Name: "displayName", Type: argTypeString},
+				{Name: "profile", Type: argTypeJSON, Description: "object of string values; the profile is kept if omitted", Optional: true},
+			},
+			handler: (*SimpleChaincode).updateOwnerProfile,
+		},
+		&functionSpec{
+			Name:        "readOwner",
+			Description: "Read a registered owner",
+			Args: []argSpec{
+				{Name: "id", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).readOwner,
+		},
+		&functionSpec{
+			Name:        "listOwners",
+			Description: "List registered owners in ID order",
+			Args: []argSpec{
+				{Name: "pageSize", Type: argTypeInteger, Description: "0 or empty for an unpaginated query"},
+				{Name: "bookmark", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).listOwners,
+		},
+		&functionSpec{
+			Name:        "setOwnerPolicy",
+			Description: "Store the policy applied to marble owners",
+			Args: []argSpec{
+				{Name: "policy", Type: argTypeJSON, Description: "requireRegistered"},
+			},
+			Roles:   []string{roleAdmin},
+			handler: (*SimpleChaincode).setOwnerPolicy,
+		},
+		&functionSpec{
+			Name:        "getOwnerPolicy",
+			Description: "Read the policy applied to marble owners",
+			ReadOnly:    true,
+			handler:     (*SimpleChaincode).getOwnerPolicy,
+		},
+		&functionSpec{
+			Name:        "createBag",
+			Description: "Create an empty bag of marbles",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+				{Name: "owner", Type: argTypeString},
+			},
+			handler: (*SimpleChaincode).createBag,
+		},
+		&functionSpec{
+			Name:        "addToBag",
+			Description: "Put a marble of the bag's owner into the bag",
+			Args: []argSpec{
+				{Name: "bag", Type: argTypeString},
+				{Name: "marble", Type: argTypeString},
+			},
+			handler: (*SimpleChaincode).addToBag,
+		},
+		&functionSpec{
+			Name:        "removeFromBag",
+			Description: "Take a marble out of a bag",
+			Args: []argSpec{
+				{Name: "bag", Type: argTypeString},
+				{Name: "marble", Type: argTypeString},
+			},
+			handler: (*SimpleChaincode).removeFromBag,
+		},
+		&functionSpec{
+			Name:        "transferBag",
+			Description: "Set a new owner on a bag and every marble it holds",
+			Args: []argSpec{
+				{Name: "bag", Type: argTypeString},
+				{Name: "newOwner", Type: argTypeString},
+			},
+			handler: (*SimpleChaincode).transferBag,
+		},
+		&functionSpec{
+			Name:        "readBag",
+			Description: "Read a bag and the names of the marbles it holds",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).readBag,
+		},
+		&functionSpec{
+			Name:        "setQuotaPolicy",
+			Description: "Store the holding quota and minting rate limit",
+			Args: []argSpec{
+				{Name: "policy", Type: argTypeJSON, Description: "trackHoldings, maxHoldings, maxMintsPerWindow, mintWindowSeconds"},
+			},
+			Roles:   []string{roleAdmin},
+			handler: (*SimpleChaincode).setQuotaPolicy,
+		},
+		&functionSpec{
+			Name:        "getQuotaPolicy",
+			Description: "Read the holding quota and minting rate limit",
+			ReadOnly:    true,
+			handler:     (*SimpleChaincode).getQuotaPolicy,
+		},
+		&functionSpec{
+			Name:        "getOwnerQuota",
+			Description: "Read the holdings of an owner and the mints of the invoker against their limits",
+			Args: []argSpec{
+				{Name: "owner", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).getOwnerQuota,
+		},
+		&functionSpec{
+			Name:        "mint",
+			Description: "Create funds in the balance of a registered owner",
+			Args: []argSpec{
+				{Name: "owner", Type: argTypeString},
+				{Name: "amount", Type: argTypeInteger},
+			},
+			Roles:   []string{roleAdmin},
+			handler: (*SimpleChaincode).mint,
+		},
+		&functionSpec{
+			Name:        "transferFunds",
+			Description: "Move funds from one owner to another",
+			Args: []argSpec{
+				{Name: "from", Type: argTypeString},
+				{Name: "to", Type: argTypeString},
+				{Name: "amount", Type: argTypeInteger},
+			},
+			handler: (*SimpleChaincode).transferFunds,
+		},
+		&functionSpec{
+			Name:        "balanceOf",
+			Description: "Read the balance of an owner",
+			Args: []argSpec{
+				{Name: "owner", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).balanceOf,
+		},
+		&functionSpec{
+			Name:        "listMarbleForSale",
+			Description: "Offer a marble for sale at a fixed price",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+				{Name: "price", Type: argTypeInteger},
+			},
+			handler: (*SimpleChaincode).listMarbleForSale,
+		},
+		&functionSpec{
+			Name:        "delistMarble",
+			Description: "Withdraw a marble from sale",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+			},
+			handler: (*SimpleChaincode).delistMarble,
+		},
+		&functionSpec{
+			Name:        "buyMarble",
+			Description: "Buy a listed marble for the owner registered by the invoker: debit it, credit the seller and transfer the marble",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+			},
+			handler: (*SimpleChaincode).buyMarble,
+		},
+		&functionSpec{
+			Name:        "readListing",
+			Description: "Read the sale listing of a marble",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).readListing,
+		},
+		&functionSpec{
+			Name:        "proposeTransfer",
+			Description: "Offer a marble to a recipient, who must accept it before expiresAt",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+				{Name: "recipient", Type: argTypeString},
+				{Name: "expiresAt", Type: argTypeString, Description: "RFC 3339 timestamp"},
+			},
+			handler: (*SimpleChaincode).proposeTransfer,
+		},
+		&functionSpec{
+			Name:        "acceptTransfer",
+			Description: "Accept the pending transfer offer of a marble",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+			},
+			handler: (*SimpleChaincode).acceptTransfer,
+		},
+		&functionSpec{
+			Name:        "rejectTransfer",
+			Description: "Decline the pending transfer offer of a marble",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+			},
+			handler: (*SimpleChaincode).rejectTransfer,
+		},
+		&functionSpec{
+			Name:        "cancelTransfer",
+			Description: "Withdraw the pending transfer offer of a marble",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+			},
+			handler: (*SimpleChaincode).cancelTransfer,
+		},
+		&functionSpec{
+			Name:        "readTransferOffer",
+			Description: "Read the pending transfer offer of a marble",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).readTransferOffer,
+		},
+		&functionSpec{
+			Name:        "lockMarble",
+			Description: "Prevent a marble from being transferred or deleted until the given time",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+				{Name: "until", Type: argTypeString, Description: "RFC 3339 timestamp, at most 366 days ahead"},
+				{Name: "reason", Type: argTypeString},
+			},
+			handler: (*SimpleChaincode).lockMarble,
+		},
+		&functionSpec{
+			Name:        "unlockMarble",
+			Description: "Remove the lock of a marble (while it is active, locking identity or admin only, unless the owner is unregistered)",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+			},
+			handler: (*SimpleChaincode).unlockMarble,
+		},
+		&functionSpec{
+			Name:        "registerAssetType",
+			Description: "Register a kind of asset with its field schema, key prefix and indexes",
+			Args: []argSpec{
+				{Name: "definition", Type: argTypeJSON, Description: "name, keyPrefix, idField, fields (name to type), indexes ([{name, fields}])"},
+			},
+			Roles:   []string{roleAdmin},
+			handler: (*SimpleChaincode).registerAssetType,
+		},
+		&functionSpec{
+			Name:        "getAssetType",
+			Description: "Read a built-in or registered asset type",
+			Args: []argSpec{
+				{Name: "name", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).getAssetType,
+		},
+		&functionSpec{
+			Name:        "listAssetTypes",
+			Description: "List the built-in and registered asset types",
+			ReadOnly:    true,
+			handler:     (*SimpleChaincode).listAssetTypes,
+		},
+		&functionSpec{
+			Name:        "createAsset",
+			Description: "Create an asset of the given type",
+			Args: []argSpec{
+				{Name: "assetType", Type: argTypeString},
+				{Name: "id", Type: argTypeString},
+				{Name: "fields", Type: argTypeJSON, Description: "every field declared by the asset type"},
+			},
+			handler: (*SimpleChaincode).createAsset,
+		},
+		&functionSpec{
+			Name:        "readAsset",
+			Description: "Read an asset of the given type",
+			Args: []argSpec{
+				{Name: "assetType", Type: argTypeString},
+				{Name: "id", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).readAsset,
+		},
+		&functionSpec{
+			Name:        "updateAsset",
+			Description: "Set some fields of an asset, other than its owner",
+			Args: []argSpec{
+				{Name: "assetType", Type: argTypeString},
+				{Name: "id", Type: argTypeString},
+				{Name: "fields", Type: argTypeJSON},
+			},
+			handler: (*SimpleChaincode).updateAsset,
+		},
+		&functionSpec{
+			Name:        "transferAsset",
+			Description: "Set a new owner on an asset",
+			Args: []argSpec{
+				{Name: "assetType", Type: argTypeString},
+				{Name: "id", Type: argTypeString},
+				{Name: "newOwner", Type: argTypeString},
+			},
+			handler: (*SimpleChaincode).transferAsset,
+		},
+		&functionSpec{
+			Name:        "deleteAsset",
+			Description: "Remove an asset and its index entries",
+			Args: []argSpec{
+				{Name: "assetType", Type: argTypeString},
+				{Name: "id", Type: argTypeString},
+			},
+			handler: (*SimpleChaincode).deleteAsset,
+		},
+		&functionSpec{
+			Name:        "queryAssetsByIndex",
+			Description: "Read the assets matching the leading fields of one of their type's indexes",
+			Args: []argSpec{
+				{Name: "assetType", Type: argTypeString},
+				{Name: "index", Type: argTypeString},
+				{Name: "values", Type: argTypeJSON, Description: "array of the leading indexed field values"},
+				{Name: "pageSize", Type: argTypeInteger, Description: "0 or empty for an unpaginated query"},
+				{Name: "bookmark", Type: argTypeString},
+			},
+			ReadOnly: true,
+			handler:  (*SimpleChaincode).queryAssetsByIndex,
+		},
+		&functionSpec{
+			Name:        "getMetadata",
+			Description: "Describe the functions of this contract",
+			ReadOnly:    true,
+			handler:     (*SimpleChaincode).getMetadata,
+		},
+	)
+}
+
+// assertAnyRole checks that the invoker holds at least one of the given roles
+func assertAnyRole(stub shim.ChaincodeStubInterface, roles []string) error {
+	var err error
+	for _, role := range roles {
+		if err = assertRole(stub, role); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// ==========================================================================
+// getMetadata - describe the functions of this contract as JSON
+// ==========================================================================
+func (t *SimpleChaincode) getMetadata(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	metadataAsBytes, err := json.Marshal(&contractMetadata{
+		Name:      contractName,
+		Transient: []string{dryRunTransientKey, traceparentTransientKey},
+		Functions: functionRegistry,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(metadataAsBytes)
+}
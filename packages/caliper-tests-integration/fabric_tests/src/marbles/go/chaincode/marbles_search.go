@@ -0,0 +1,228 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Typed search (Only supported if CouchDB is used as state database) ====
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["searchMarbles","{\"owner\":\"tom\",\"colors\":[\"blue\",\"red\"]}"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["searchMarbles","{\"minSize\":10,\"maxSize\":50,\"sortBy\":\"size\",\"sortDirection\":\"desc\",\"pageSize\":10}"]}'
+//
+// The queries built by searchMarbles are served by the indexes packaged in
+// metadata/statedb/couchdb/indexes:
+//   indexOwner.json        - docType, owner
+//   indexColor.json        - docType, color
+//   indexSizeSortDesc.json - size, docType, owner (descending), used for size-sorted queries
+//   indexOwnerSize.json    - docType, owner, size, used for size-sorted queries of a single owner
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// maxSearchPageSize bounds the page size a searchMarbles query may request
+const maxSearchPageSize = 1000
+
+// marbleSearchFilter is the typed filter accepted by searchMarbles. Every field is optional.
+type marbleSearchFilter struct {
+	Owner         string   `json:"owner"`
+	Colors        []string `json:"colors"`
+	MinSize       *int     `json:"minSize"`
+	MaxSize       *int     `json:"maxSize"`
+	SortBy        string   `json:"sortBy"`        // "" or "size"
+	SortDirection string   `json:"sortDirection"` // "asc" (default) or "desc"
+	PageSize      int32    `json:"pageSize"`      // 0 returns every match in one response
+	Bookmark      string   `json:"bookmark"`
+}
+
+// queryRecord is a single key/value pair of a query result
+type queryRecord struct {
+	Key    string          `json:"Key"`
+	Record json.RawMessage `json:"Record"`
+}
+
+// richQueryResult is the response of rich queries that return structured results
+type richQueryResult struct {
+	Records             []queryRecord `json:"records"`
+	FetchedRecordsCount int32         `json:"fetchedRecordsCount"`
+	Bookmark            string        `json:"bookmark"`
+}
+
+// parseMarbleSearchFilter decodes and validates a search filter. Unknown fields are rejected
+// so that a misspelled filter does not silently match every marble.
+func parseMarbleSearchFilter(filterJSON string) (*marbleSearchFilter, error) {
+	filter := &marbleSearchFilter{}
+	if len(filterJSON) > 0 {
+		decoder := json.NewDecoder(strings.NewReader(filterJSON))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(filter); err != nil {
+			return nil, fmt.Errorf("invalid search filter: %s", err.Error())
+		}
+	}
+
+	filter.Owner = strings.ToLower(filter.Owner)
+	for i, color := range filter.Colors {
+		if len(color) <= 0 {
+			return nil, fmt.Errorf("colors must be non-empty strings")
+		}
+		filter.Colors[i] = strings.ToLower(color)
+	}
+	if filter.MinSize != nil && filter.MaxSize != nil && *filter.MinSize > *filter.MaxSize {
+		return nil, fmt.Errorf("minSize must not be greater than maxSize")
+	}
+	if filter.SortBy != "" && filter.SortBy != "size" {
+		return nil, fmt.Errorf("unsupported sortBy %q, expecting \"size\"", filter.SortBy)
+	}
+	switch filter.SortDirection {
+	case "":
+		filter.SortDirection = "asc"
+	case "asc", "desc":
+	default:
+		return nil, fmt.Errorf("unsupported sortDirection %q, expecting \"asc\" or \"desc\"", filter.SortDirection)
+	}
+	if filter.PageSize < 0 || filter.PageSize > maxSearchPageSize {
+		return nil, fmt.Errorf("pageSize must be between 0 and %d", maxSearchPageSize)
+	}
+	return filter, nil
+}
+
+// buildMarbleSearchQuery builds the Mango query for a filter. The query is assembled from Go
+// values and marshalled, so filter values can never change the structure of the query.
+func buildMarbleSearchQuery(filter *marbleSearchFilter) ([]byte, error) {
+	selector := map[string]interface{}{"docType": "marble"}
+	query := map[string]interface{}{"selector": selector}
+
+	if len(filter.Owner) > 0 {
+		selector["owner"] = filter.Owner
+	}
+	if len(filter.Colors) == 1 {
+		selector["color"] = filter.Colors[0]
+	} else if len(filter.Colors) > 1 {
+		selector["color"] = map[string]interface{}{"$in": filter.Colors}
+	}
+
+	sizeCondition := map[string]interface{}{}
+	if filter.MinSize != nil {
+		sizeCondition["$gte"] = *filter.MinSize
+	}
+	if filter.MaxSize != nil {
+		sizeCondition["$lte"] = *filter.MaxSize
+	}
+
+	if filter.SortBy == "size" {
+		// CouchDB only uses an index if the selector references every indexed field,
+		// so unconstrained fields of the sort index are matched with "greater than null"
+		if len(sizeCondition) == 0 {
+			sizeCondition["$gt"] = nil
+		}
+		direction := filter.SortDirection
+		if len(filter.Owner) > 0 {
+			query["sort"] = []map[string]string{{"docType": direction}, {"owner": direction}, {"size": direction}}
+			query["use_index"] = []string{"_design/indexOwnerSizeDoc", "indexOwnerSize"}
+		} else {
+			selector["owner"] = map[string]interface{}{"$gt": nil}
+			query["sort"] = []map[string]string{{"size": direction}, {"docType": direction}, {"owner": direction}}
+			query["use_index"] = []string{"_design/indexSizeSortDoc", "indexSizeSortDesc"}
+		}
+	}
+	if len(sizeCondition) > 0 {
+		selector["size"] = sizeCondition
+	}
+
+	return json.Marshal(query)
+}
+
+// collectQueryRecords drains a result iterator into a slice of query records
+func collectQueryRecords(resultsIterator shim.StateQueryIteratorInterface) ([]queryRecord, error) {
+	records := []queryRecord{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, queryRecord{Key: queryResponse.Key, Record: json.RawMessage(queryResponse.Value)})
+	}
+	return records, nil
+}
+
+// executeRichQuery runs a rich query, paginated if pageSize is positive, and collects its records
+func executeRichQuery(stub shim.ChaincodeStubInterface, queryString string, pageSize int32, bookmark string) (*richQueryResult, error) {
+	result := &richQueryResult{}
+	var resultsIterator shim.StateQueryIteratorInterface
+	var err error
+	if pageSize > 0 {
+		var responseMetadata *pb.QueryResponseMetadata
+		resultsIterator, responseMetadata, err = stub.GetQueryResultWithPagination(queryString, pageSize, bookmark)
+		if err != nil {
+			return nil, err
+		}
+		result.FetchedRecordsCount = responseMetadata.FetchedRecordsCount
+		result.Bookmark = responseMetadata.Bookmark
+	} else {
+		resultsIterator, err = stub.GetQueryResult(queryString)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer resultsIterator.Close()
+
+	result.Records, err = collectQueryRecords(resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		result.FetchedRecordsCount = int32(len(result.Records))
+	}
+	return result, nil
+}
+
+// ===== Example: Typed rich query ===========================================================
+// searchMarbles queries for marbles based on a typed filter object (owner, colors, size range,
+// sort order and pagination). Unlike queryMarbles, the client never supplies query syntax;
+// the chaincode builds the query and chooses a matching packaged index.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// ===========================================================================================
+func (t *SimpleChaincode) searchMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "{\"owner\":\"tom\"}"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	filter, err := parseMarbleSearchFilter(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	queryString, err := buildMarbleSearchQuery(filter)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("- searchMarbles queryString:\n%s\n", queryString)
+
+	result, err := executeRichQuery(stub, string(queryString), filter.PageSize, filter.Bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,202 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Seed marbles ====
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["seedMarbles","seed_","100","42","",""]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["seedMarbles","seed_","100","42","{\"blue\":3,\"red\":1}","{\"alice\":1,\"bob\":1}"]}'
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	// maxSeedCount bounds the number of marbles a single seedMarbles transaction may create,
+	// so that a single call cannot produce an arbitrarily large write set.
+	maxSeedCount = 10000
+	minSeedSize  = 1
+	maxSeedSize  = 100
+)
+
+// defaultSeedColors and defaultSeedOwners match the values used by the Caliper init.js workload.
+var defaultSeedColors = map[string]uint64{"red": 1, "blue": 1, "green": 1, "black": 1, "white": 1, "pink": 1, "rainbow": 1}
+var defaultSeedOwners = map[string]uint64{"alice": 1, "bob": 1, "claire": 1, "david": 1}
+
+// seedRandom is a splitmix64 generator. It is used instead of math/rand so that the
+// generated sequence depends only on the seed argument and is identical on every peer.
+type seedRandom struct {
+	state uint64
+}
+
+func (r *seedRandom) next() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	z := r.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// intn returns a value in [0, n)
+func (r *seedRandom) intn(n uint64) uint64 {
+	return r.next() % n
+}
+
+// weightedChoice is a value with a relative weight, used to pick colors and owners
+type weightedChoice struct {
+	value  string
+	weight uint64
+}
+
+// weightedDistribution picks values proportionally to their weights
+type weightedDistribution struct {
+	choices []weightedChoice
+	total   uint64
+}
+
+// newWeightedDistribution builds a distribution from a value->weight map. Values are sorted
+// so that the outcome does not depend on Go's randomized map iteration order.
+func newWeightedDistribution(weights map[string]uint64) (*weightedDistribution, error) {
+	dist := &weightedDistribution{}
+	values := make([]string, 0, len(weights))
+	for value := range weights {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	for _, value := range values {
+		if len(value) <= 0 {
+			return nil, fmt.Errorf("distribution values must be non-empty strings")
+		}
+		weight := weights[value]
+		if weight == 0 {
+			continue
+		}
+		dist.choices = append(dist.choices, weightedChoice{value, weight})
+		dist.total += weight
+	}
+	if dist.total == 0 {
+		return nil, fmt.Errorf("distribution must contain at least one value with a positive weight")
+	}
+	return dist, nil
+}
+
+// parseWeightedDistribution parses a JSON object of value->weight pairs, falling back to the
+// given defaults for an empty argument
+func parseWeightedDistribution(arg string, defaults map[string]uint64) (*weightedDistribution, error) {
+	if len(arg) <= 0 {
+		return newWeightedDistribution(defaults)
+	}
+	weights := map[string]uint64{}
+	if err := json.Unmarshal([]byte(arg), &weights); err != nil {
+		return nil, fmt.Errorf("distribution must be a JSON object of value to non-negative integer weight: %s", err.Error())
+	}
+	return newWeightedDistribution(weights)
+}
+
+func (d *weightedDistribution) pick(r *seedRandom) string {
+	n := r.intn(d.total)
+	for _, choice := range d.choices {
+		if n < choice.weight {
+			return choice.value
+		}
+		n -= choice.weight
+	}
+	return d.choices[len(d.choices)-1].value
+}
+
+// seedFromString derives the generator state from the seed argument. Numeric seeds are used
+// directly, any other string is hashed.
+func seedFromString(seed string) uint64 {
+	if n, err := strconv.ParseInt(seed, 10, 64); err == nil {
+		return uint64(n)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	return h.Sum64()
+}
+
+type seedResult struct {
+	Prefix string `json:"prefix"`
+	Count  int    `json:"count"`
+	Seed   string `json:"seed"`
+}
+
+// ===============================================================================================
+// seedMarbles - create a reproducible set of marbles named <prefix>0 .. <prefix><count-1>.
+// Colors, sizes and owners are derived from the seed only, so every endorsing peer generates
+// the same write set and the same seed always produces the same dataset.
+// ===============================================================================================
+func (t *SimpleChaincode) seedMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//    0          1       2          3                   4
+	// "prefix", "100", "42", "{\"blue\":3}", "{\"alice\":1}"
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+
+	prefix := args[0]
+	if len(prefix) <= 0 {
+		return shim.Error("1st argument must be a non-empty string")
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return shim.Error("2nd argument must be a numeric string")
+	}
+	if count <= 0 || count > maxSeedCount {
+		return shim.Error(fmt.Sprintf("2nd argument must be between 1 and %d", maxSeedCount))
+	}
+	if len(args[2]) <= 0 {
+		return shim.Error("3rd argument must be a non-empty string")
+	}
+	colors, err := parseWeightedDistribution(args[3], defaultSeedColors)
+	if err != nil {
+		return shim.Error("Invalid color distribution: " + err.Error())
+	}
+	owners, err := parseWeightedDistribution(args[4], defaultSeedOwners)
+	if err != nil {
+		return shim.Error("Invalid owner distribution: " + err.Error())
+	}
+
+	fmt.Printf("- start seedMarbles prefix:%s count:%d seed:%s\n", prefix, count, args[2])
+
+	random := &seedRandom{state: seedFromString(args[2])}
+	for i := 0; i < count; i++ {
+		marbleName := prefix + strconv.Itoa(i)
+		color := colors.pick(random)
+		size := minSeedSize + int(random.intn(maxSeedSize-minSeedSize+1))
+		owner := owners.pick(random)
+
+		// Re-use the same function that is used to create individual marbles
+		response := t.initMarble(stub, []string{marbleName, color, strconv.Itoa(size), owner})
+		if response.Status != shim.OK {
+			return shim.Error("Seeding failed: " + response.Message)
+		}
+	}
+
+	resultAsBytes, err := json.Marshal(seedResult{Prefix: prefix, Count: count, Seed: args[2]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end seedMarbles")
+	return shim.Success(resultAsBytes)
+}
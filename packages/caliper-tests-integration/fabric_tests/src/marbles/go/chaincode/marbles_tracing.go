@@ -0,0 +1,428 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Tracing ====
+// When CHAINCODE_TRACE_FILE (a file path) or CHAINCODE_TRACE_ENDPOINT (an HTTP URL of a local
+// collector) is set, every invocation produces an "Invoke" span and one child span per state
+// operation, exported as JSON lines. A client continues its own trace by passing a W3C
+// traceparent value in the transient map:
+//
+// export TRACEPARENT=$(echo -n "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" | base64 | tr -d \\n)
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["transferMarble","marble2","jerry"]}' --transient "{\"traceparent\":\"$TRACEPARENT\"}"
+//
+// Invocations without a traceparent start a new trace. Invocations whose traceparent is not
+// sampled (flags 00) are not exported.
+
+package chaincode
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	// traceFileEnv and traceEndpointEnv select where spans are exported
+	traceFileEnv     = "CHAINCODE_TRACE_FILE"
+	traceEndpointEnv = "CHAINCODE_TRACE_ENDPOINT"
+
+	// traceparentTransientKey is the transient map entry holding the W3C traceparent
+	traceparentTransientKey = "traceparent"
+
+	traceServiceName = "marbles-chaincode"
+)
+
+// span is a single exported span
+type span struct {
+	TraceID           string                 `json:"traceId"`
+	SpanID            string                 `json:"spanId"`
+	ParentSpanID      string                 `json:"parentSpanId,omitempty"`
+	Name              string                 `json:"name"`
+	Service           string                 `json:"service"`
+	StartTimeUnixNano int64                  `json:"startTimeUnixNano"`
+	EndTimeUnixNano   int64                  `json:"endTimeUnixNano"`
+	Status            string                 `json:"status"`
+	Attributes        map[string]interface{} `json:"attributes"`
+}
+
+// spanExporter exports the finished spans of an invocation
+type spanExporter interface {
+	export(spans []*span) error
+}
+
+// fileSpanExporter appends spans as JSON lines to a file
+type fileSpanExporter struct {
+	mutex sync.Mutex
+	path  string
+}
+
+func (e *fileSpanExporter) export(spans []*span) error {
+	lines, err := encodeSpans(spans)
+	if err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	file, err := os.OpenFile(e.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(lines); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// httpSpanExporter posts spans as JSON lines to a collector endpoint
+type httpSpanExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+func (e *httpSpanExporter) export(spans []*span) error {
+	lines, err := encodeSpans(spans)
+	if err != nil {
+		return err
+	}
+	response, err := e.client.Post(e.endpoint, "application/x-ndjson", bytes.NewReader(lines))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("collector returned status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// encodeSpans encodes spans as newline-delimited JSON
+func encodeSpans(spans []*span) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, s := range spans {
+		if err := encoder.Encode(s); err != nil {
+			return nil, err
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+// tracer creates invocation traces and hands their spans to an exporter
+type tracer struct {
+	exporter spanExporter
+}
+
+// newTracerFromEnv returns a tracer configured from the environment, or nil if tracing is disabled
+func newTracerFromEnv() *tracer {
+	if path := os.Getenv(traceFileEnv); path != "" {
+		return &tracer{exporter: &fileSpanExporter{path: path}}
+	}
+	if endpoint := os.Getenv(traceEndpointEnv); endpoint != "" {
+		return &tracer{exporter: &httpSpanExporter{endpoint: endpoint, client: &http.Client{Timeout: 5 * time.Second}}}
+	}
+	return nil
+}
+
+// randomHex returns n random bytes encoded as hex. Span IDs never reach the ledger, so they
+// do not need to be deterministic across endorsers.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// isHex reports whether s is a lower-case hex string of the given length that is not all zeros
+func isHex(s string, length int) bool {
+	if len(s) != length || strings.Trim(s, "0") == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTraceparent parses a W3C traceparent header value
+func parseTraceparent(traceparent string) (traceID, parentID string, sampled bool, ok bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[3]) != 2 {
+		return "", "", false, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", "", false, false
+	}
+	if !isHex(parts[1], 32) || !isHex(parts[2], 16) {
+		return "", "", false, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return "", "", false, false
+	}
+	return parts[1], parts[2], flags[0]&0x01 == 0x01, true
+}
+
+// invocationTrace collects the spans of a single invocation
+type invocationTrace struct {
+	tracer  *tracer
+	sampled bool
+	root    *span
+	mutex   sync.Mutex
+	spans   []*span
+}
+
+// start begins the trace of an invocation, continuing the client's trace if the transient map
+// carries a valid traceparent
+func (t *tracer) start(stub shim.ChaincodeStubInterface, function string) *invocationTrace {
+	traceID, parentID, sampled := randomHex(16), "", true
+	if transientMap, err := stub.GetTransient(); err == nil {
+		if traceparent, ok := transientMap[traceparentTransientKey]; ok {
+			if id, parent, isSampled, valid := parseTraceparent(string(traceparent)); valid {
+				traceID, parentID, sampled = id, parent, isSampled
+			}
+		}
+	}
+
+	trace := &invocationTrace{tracer: t, sampled: sampled}
+	trace.root = &span{
+		TraceID:           traceID,
+		SpanID:            randomHex(8),
+		ParentSpanID:      parentID,
+		Name:              "Invoke",
+		Service:           traceServiceName,
+		StartTimeUnixNano: time.Now().UnixNano(),
+		Attributes: map[string]interface{}{
+			"fabric.tx_id":       stub.GetTxID(),
+			"fabric.channel_id":  stub.GetChannelID(),
+			"chaincode.function": function,
+		},
+	}
+	return trace
+}
+
+// startSpan begins a child span of the invocation span
+func (trace *invocationTrace) startSpan(name string, attributes map[string]interface{}) *span {
+	attributes["fabric.tx_id"] = trace.root.Attributes["fabric.tx_id"]
+	return &span{
+		TraceID:           trace.root.TraceID,
+		SpanID:            randomHex(8),
+		ParentSpanID:      trace.root.SpanID,
+		Name:              name,
+		Service:           traceServiceName,
+		StartTimeUnixNano: time.Now().UnixNano(),
+		Attributes:        attributes,
+	}
+}
+
+// endSpan finishes a child span
+func (trace *invocationTrace) endSpan(s *span, err error) {
+	s.EndTimeUnixNano = time.Now().UnixNano()
+	s.Status = "ok"
+	if err != nil {
+		s.Status = "error"
+		s.Attributes["error"] = err.Error()
+	}
+	trace.mutex.Lock()
+	trace.spans = append(trace.spans, s)
+	trace.mutex.Unlock()
+}
+
+// finish ends the invocation span and exports the trace in the background
+func (trace *invocationTrace) finish(response pb.Response) {
+	trace.root.EndTimeUnixNano = time.Now().UnixNano()
+	trace.root.Status = "ok"
+	trace.root.Attributes["chaincode.status"] = response.Status
+	trace.root.Attributes["chaincode.response_bytes"] = len(response.Payload)
+	if response.Status >= shim.ERRORTHRESHOLD {
+		trace.root.Status = "error"
+		trace.root.Attributes["error"] = response.Message
+	}
+	if !trace.sampled {
+		return
+	}
+
+	trace.mutex.Lock()
+	spans := append([]*span{trace.root}, trace.spans...)
+	trace.mutex.Unlock()
+	go func() {
+		if err := trace.tracer.exporter.export(spans); err != nil {
+			fmt.Printf("Error exporting trace %s: %s\n", trace.root.TraceID, err)
+		}
+	}()
+}
+
+// tracedStub creates a span for every state operation issued through it
+type tracedStub struct {
+	shim.ChaincodeStubInterface
+	trace *invocationTrace
+}
+
+func (s *tracedStub) GetState(key string) ([]byte, error) {
+	sp := s.trace.startSpan("GetState", map[string]interface{}{"key": key})
+	value, err := s.ChaincodeStubInterface.GetState(key)
+	sp.Attributes["value_bytes"] = len(value)
+	s.trace.endSpan(sp, err)
+	return value, err
+}
+
+func (s *tracedStub) PutState(key string, value []byte) error {
+	sp := s.trace.startSpan("PutState", map[string]interface{}{"key": key, "value_bytes": len(value)})
+	err := s.ChaincodeStubInterface.PutState(key, value)
+	s.trace.endSpan(sp, err)
+	return err
+}
+
+func (s *tracedStub) DelState(key string) error {
+	sp := s.trace.startSpan("DelState", map[string]interface{}{"key": key})
+	err := s.ChaincodeStubInterface.DelState(key)
+	s.trace.endSpan(sp, err)
+	return err
+}
+
+func (s *tracedStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	sp := s.trace.startSpan("GetStateByRange", map[string]interface{}{"start_key": startKey, "end_key": endKey})
+	iterator, err := s.ChaincodeStubInterface.GetStateByRange(startKey, endKey)
+	return s.wrapIterator(sp, iterator, err), err
+}
+
+func (s *tracedStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	sp := s.trace.startSpan("GetStateByRangeWithPagination", map[string]interface{}{"start_key": startKey, "end_key": endKey, "page_size": pageSize})
+	iterator, metadata, err := s.ChaincodeStubInterface.GetStateByRangeWithPagination(startKey, endKey, pageSize, bookmark)
+	return s.wrapIterator(sp, iterator, err), metadata, err
+}
+
+func (s *tracedStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	sp := s.trace.startSpan("GetStateByPartialCompositeKey", map[string]interface{}{"object_type": objectType, "attributes": keys})
+	iterator, err := s.ChaincodeStubInterface.GetStateByPartialCompositeKey(objectType, keys)
+	return s.wrapIterator(sp, iterator, err), err
+}
+
+func (s *tracedStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	sp := s.trace.startSpan("GetStateByPartialCompositeKeyWithPagination", map[string]interface{}{"object_type": objectType, "attributes": keys, "page_size": pageSize})
+	iterator, metadata, err := s.ChaincodeStubInterface.GetStateByPartialCompositeKeyWithPagination(objectType, keys, pageSize, bookmark)
+	return s.wrapIterator(sp, iterator, err), metadata, err
+}
+
+func (s *tracedStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	sp := s.trace.startSpan("GetQueryResult", map[string]interface{}{"query": query})
+	iterator, err := s.ChaincodeStubInterface.GetQueryResult(query)
+	return s.wrapIterator(sp, iterator, err), err
+}
+
+func (s *tracedStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	sp := s.trace.startSpan("GetQueryResultWithPagination", map[string]interface{}{"query": query, "page_size": pageSize})
+	iterator, metadata, err := s.ChaincodeStubInterface.GetQueryResultWithPagination(query, pageSize, bookmark)
+	return s.wrapIterator(sp, iterator, err), metadata, err
+}
+
+func (s *tracedStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	sp := s.trace.startSpan("GetHistoryForKey", map[string]interface{}{"key": key})
+	iterator, err := s.ChaincodeStubInterface.GetHistoryForKey(key)
+	if err != nil || iterator == nil {
+		s.trace.endSpan(sp, err)
+		return iterator, err
+	}
+	return &tracedHistoryIterator{HistoryQueryIteratorInterface: iterator, trace: s.trace, span: sp}, nil
+}
+
+func (s *tracedStub) GetPrivateData(collection, key string) ([]byte, error) {
+	sp := s.trace.startSpan("GetPrivateData", map[string]interface{}{"collection": collection, "key": key})
+	value, err := s.ChaincodeStubInterface.GetPrivateData(collection, key)
+	s.trace.endSpan(sp, err)
+	return value, err
+}
+
+func (s *tracedStub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	sp := s.trace.startSpan("GetPrivateDataHash", map[string]interface{}{"collection": collection, "key": key})
+	value, err := s.ChaincodeStubInterface.GetPrivateDataHash(collection, key)
+	s.trace.endSpan(sp, err)
+	return value, err
+}
+
+func (s *tracedStub) PutPrivateData(collection, key string, value []byte) error {
+	sp := s.trace.startSpan("PutPrivateData", map[string]interface{}{"collection": collection, "key": key, "value_bytes": len(value)})
+	err := s.ChaincodeStubInterface.PutPrivateData(collection, key, value)
+	s.trace.endSpan(sp, err)
+	return err
+}
+
+func (s *tracedStub) DelPrivateData(collection, key string) error {
+	sp := s.trace.startSpan("DelPrivateData", map[string]interface{}{"collection": collection, "key": key})
+	err := s.ChaincodeStubInterface.DelPrivateData(collection, key)
+	s.trace.endSpan(sp, err)
+	return err
+}
+
+// wrapIterator keeps a query span open until its iterator is closed
+func (s *tracedStub) wrapIterator(sp *span, iterator shim.StateQueryIteratorInterface, err error) shim.StateQueryIteratorInterface {
+	if err != nil || iterator == nil {
+		s.trace.endSpan(sp, err)
+		return iterator
+	}
+	return &tracedStateIterator{StateQueryIteratorInterface: iterator, trace: s.trace, span: sp}
+}
+
+// tracedStateIterator ends its query span when closed, recording the number of results read
+type tracedStateIterator struct {
+	shim.StateQueryIteratorInterface
+	trace   *invocationTrace
+	span    *span
+	results int
+}
+
+func (i *tracedStateIterator) Next() (*queryresult.KV, error) {
+	i.results++
+	return i.StateQueryIteratorInterface.Next()
+}
+
+func (i *tracedStateIterator) Close() error {
+	err := i.StateQueryIteratorInterface.Close()
+	i.span.Attributes["results"] = i.results
+	i.trace.endSpan(i.span, err)
+	return err
+}
+
+// tracedHistoryIterator ends its query span when closed, recording the number of results read
+type tracedHistoryIterator struct {
+	shim.HistoryQueryIteratorInterface
+	trace   *invocationTrace
+	span    *span
+	results int
+}
+
+func (i *tracedHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	i.results++
+	return i.HistoryQueryIteratorInterface.Next()
+}
+
+func (i *tracedHistoryIterator) Close() error {
+	err := i.HistoryQueryIteratorInterface.Close()
+	i.span.Attributes["results"] = i.results
+	i.trace.endSpan(i.span, err)
+	return err
+}
@@ -0,0 +1,280 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// ==== Two-phase transfers ====
+// The owner of a marble proposes a transfer, and the marble only moves once the recipient
+// accepts it. A marble has at most one pending offer, which lapses at expiresAt, evaluated
+// against the transaction timestamp, and which goes stale if the marble changes in the meantime.
+// Acting for the owner or the recipient follows the owner registry (see assertActsFor).
+//
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["proposeTransfer","marble1","jerry","2030-01-01T00:00:00Z"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["acceptTransfer","marble1"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["rejectTransfer","marble1"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["cancelTransfer","marble1"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["readTransferOffer","marble1"]}'
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// transferOfferObjectType is the docType and composite key object type of pending transfer offers
+const transferOfferObjectType = "transferOffer"
+
+// transferOffer is a proposed transfer of a marble awaiting the recipient's answer
+type transferOffer struct {
+	ObjectType string `json:"docType"`
+	Marble     string `json:"marble"`
+	From       string `json:"from"` // owner of the marble when the transfer was proposed
+	Recipient  string `json:"recipient"`
+	ProposedAt string `json:"proposedAt"`
+	ExpiresAt  string `json:"expiresAt"` // RFC 3339
+	ProposalTx string `json:"proposalTxId"`
+	MarbleTxID string `json:"marbleTxId"` // lastTxId of the marble when the transfer was proposed
+}
+
+// txTime returns the timestamp of the transaction proposal
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get transaction timestamp: %s", err.Error())
+	}
+	return time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC(), nil
+}
+
+// stale reports whether a marble has changed since the transfer was proposed, e.g. it left
+// the proposer and came back, so that the proposer's consent no longer holds
+func (o *transferOffer) stale(marbleDoc *marble) bool {
+	return marbleDoc.LastTxID != o.MarbleTxID || marbleDoc.Owner != o.From
+}
+
+// expired reports whether an offer has lapsed at the given time
+func (o *transferOffer) expired(now time.Time) bool {
+	expiresAt, err := time.Parse(time.RFC3339Nano, o.ExpiresAt)
+	return err != nil || !now.Before(expiresAt)
+}
+
+// getTransferOffer reads the pending offer of a marble, or returns nil if there is none
+func getTransferOffer(stub shim.ChaincodeStubInterface, marbleName string) (string, *transferOffer, error) {
+	offerKey, err := stub.CreateCompositeKey(transferOfferObjectType, []string{marbleName})
+	if err != nil {
+		return "", nil, err
+	}
+	offerAsBytes, err := stub.GetState(offerKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get transfer offer: %s", err.Error())
+	} else if offerAsBytes == nil {
+		return offerKey, nil, nil
+	}
+	offer := &transferOffer{}
+	if err := json.Unmarshal(offerAsBytes, offer); err != nil {
+		return "", nil, err
+	}
+	return offerKey, offer, nil
+}
+
+// mustGetTransferOffer reads the pending offer of a marble, failing if there is none
+func mustGetTransferOffer(stub shim.ChaincodeStubInterface, marbleName string) (string, *transferOffer, error) {
+	offerKey, offer, err := getTransferOffer(stub, marbleName)
+	if err != nil {
+		return "", nil, err
+	} else if offer == nil {
+		return "", nil, fmt.Errorf("marble %s has no pending transfer offer", marbleName)
+	}
+	return offerKey, offer, nil
+}
+
+// ==========================================================================================
+// proposeTransfer - offer a marble to a recipient until expiresAt. An expired offer of the
+// same marble is replaced; a pending one must be cancelled first.
+// ==========================================================================================
+func (t *SimpleChaincode) proposeTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//     0         1                2
+	// "marble1", "jerry", "2030-01-01T00:00:00Z"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	marbleName := args[0]
+	recipient := strings.ToLower(args[1])
+	if len(recipient) <= 0 {
+		return shim.Error("2nd argument must be a non-empty string")
+	}
+	expiresAt, err := time.Parse(time.RFC3339Nano, args[2])
+	if err != nil {
+		return shim.Error("3rd argument must be an RFC 3339 timestamp")
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !now.Before(expiresAt) {
+		return shim.Error("3rd argument must be after the transaction timestamp")
+	}
+	fmt.Println("- start proposeTransfer ", marbleName, recipient)
+
+	marbleDoc, err := getMarble(stub, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if marbleDoc.Bag != "" {
+		return shim.Error("Marble " + marbleName + " is in bag " + marbleDoc.Bag + "; transfer the bag instead")
+	}
+	if recipient == marbleDoc.Owner {
+		return shim.Error("Marble " + marbleName + " is already owned by " + recipient)
+	}
+	if err := assertActsFor(stub, marbleDoc.Owner); err != nil {
+		return shim.Error(err.Error())
+	}
+	offerKey, existing, err := getTransferOffer(stub, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if existing != nil && !existing.expired(now) && !existing.stale(marbleDoc) {
+		return shim.Error("Marble " + marbleName + " already has a pending transfer offer to " + existing.Recipient)
+	}
+
+	offer := &transferOffer{
+		ObjectType: transferOfferObjectType,
+		Marble:     marbleName,
+		From:       marbleDoc.Owner,
+		Recipient:  recipient,
+		ProposedAt: now.Format(time.RFC3339Nano),
+		ExpiresAt:  expiresAt.UTC().Format(time.RFC3339Nano),
+		ProposalTx: stub.GetTxID(),
+		MarbleTxID: marbleDoc.LastTxID,
+	}
+	offerAsBytes, err := json.Marshal(offer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(offerKey, offerAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end proposeTransfer")
+	return shim.Success(nil)
+}
+
+// ==========================================================================================
+// acceptTransfer - the recipient accepts the pending offer of a marble, which is transferred
+// if the offer has not expired and the marble has not changed hands since it was proposed
+// ==========================================================================================
+func (t *SimpleChaincode) acceptTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//     0
+	// "marble1"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	marbleName := args[0]
+
+	offerKey, offer, err := mustGetTransferOffer(stub, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := assertActsFor(stub, offer.Recipient); err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if offer.expired(now) {
+		return shim.Error("The transfer offer of marble " + marbleName + " expired at " + offer.ExpiresAt)
+	}
+	marbleDoc, err := getMarble(stub, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if offer.stale(marbleDoc) {
+		return shim.Error("The transfer offer of marble " + marbleName + " is stale: the marble changed at transaction " + marbleDoc.LastTxID)
+	}
+
+	// The owner agreed by proposing the transfer, and the recipient by accepting it
+	if err := moveMarble(stub, marbleDoc, offer.Recipient); err != nil {
+		return shim.Error("Transfer failed: " + err.Error())
+	}
+	if err := stub.DelState(offerKey); err != nil {
+		return shim.Error("Failed to delete state:" + err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// rejectTransfer - the recipient declines the pending offer
+// ============================================================
+func (t *SimpleChaincode) rejectTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	offerKey, offer, err := mustGetTransferOffer(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := assertActsFor(stub, offer.Recipient); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.DelState(offerKey); err != nil {
+		return shim.Error("Failed to delete state:" + err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// cancelTransfer - the proposer withdraws the pending offer
+// ============================================================
+func (t *SimpleChaincode) cancelTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	offerKey, offer, err := mustGetTransferOffer(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := assertActsFor(stub, offer.From); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.DelState(offerKey); err != nil {
+		return shim.Error("Failed to delete state:" + err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readTransferOffer - read the pending offer of a marble
+// ============================================================
+func (t *SimpleChaincode) readTransferOffer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the marble to query")
+	}
+
+	_, offer, err := mustGetTransferOffer(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	offerAsBytes, err := json.Marshal(offer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(offerAsBytes)
+}
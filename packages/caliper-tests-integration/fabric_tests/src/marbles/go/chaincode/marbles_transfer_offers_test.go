@@ -0,0 +1,83 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+
+
+package chaincode
+
+import (
+	"testing"
+	"time"
+)
+
+// offerTestTime is the timestamp at which tom proposes the transfer of marble1 to jerry
+var offerTestTime = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newOfferLedger returns a ledger where tom offers marble1 to jerry for an hour
+func newOfferLedger(t *testing.T) *testLedger {
+	l := newTestLedger(t)
+	l.now = offerTestTime
+	l.mustInvoke("tom", "registerOwner", "tom", "Tom")
+	l.mustInvoke("jerry", "registerOwner", "jerry", "Jerry")
+	l.mustInvoke("tom", "initMarble", "marble1", "blue", "35", "tom")
+	l.mustInvoke("tom", "proposeTransfer", "marble1", "jerry", offerTestTime.Add(time.Hour).Format(time.RFC3339))
+	return l
+}
+
+func TestTransferOfferExpiry(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		offset time.Duration // after offerTestTime
+		want   string        // error, or empty if the transfer is accepted
+	}{
+		{name: "before expiry", offset: time.Hour - time.Second},
+		{name: "at expiry", offset: time.Hour, want: "The transfer offer of marble marble1 expired at 2030-01-01T01:00:00Z"},
+		{name: "after expiry", offset: 2 * time.Hour, want: "expired"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := newOfferLedger(t)
+			l.now = offerTestTime.Add(test.offset)
+			if test.want != "" {
+				l.mustFail(test.want, "jerry", "acceptTransfer", "marble1")
+				// an expired offer no longer blocks a new one
+				l.mustInvoke("tom", "proposeTransfer", "marble1", "spike", l.now.Add(time.Hour).Format(time.RFC3339))
+				return
+			}
+			l.mustInvoke("jerry", "acceptTransfer", "marble1")
+			if marbleDoc := readTestMarble(l, "marble1"); marbleDoc.Owner != "jerry" {
+				t.Errorf("marble1 is owned by %s, expected jerry", marbleDoc.Owner)
+			}
+		})
+	}
+}
+
+func TestProposeTransferExpiry(t *testing.T) {
+	l := newOfferLedger(t)
+	l.mustFail("must be after the transaction timestamp", "tom", "proposeTransfer", "marble1", "spike", offerTestTime.Format(time.RFC3339))
+	l.mustFail("already has a pending transfer offer to jerry", "tom", "proposeTransfer", "marble1", "spike", offerTestTime.Add(time.Hour).Format(time.RFC3339))
+}
+
+func TestStaleTransferOffer(t *testing.T) {
+	l := newOfferLedger(t)
+	// the marble leaves tom and comes back
+	l.mustInvoke("tom", "transferMarble", "marble1", "spike")
+	l.mustInvoke("spike", "transferMarble", "marble1", "tom")
+
+	l.mustFail("The transfer offer of marble marble1 is stale", "jerry", "acceptTransfer", "marble1")
+	if marbleDoc := readTestMarble(l, "marble1"); marbleDoc.Owner != "tom" {
+		t.Errorf("marble1 is owned by %s, expected tom", marbleDoc.Owner)
+	}
+	// a stale offer no longer blocks a new one
+	l.mustInvoke("tom", "proposeTransfer", "marble1", "spike", offerTestTime.Add(time.Hour).Format(time.RFC3339))
+}
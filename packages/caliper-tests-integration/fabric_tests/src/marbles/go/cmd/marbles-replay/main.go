@@ -0,0 +1,469 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// marbles-replay replays a recorded workload trace against the marbles chaincode in-process,
+// on an in-memory ledger, and reports per-function latency percentiles, failures by error
+// message and a digest of the final state.
+//
+// The trace holds one JSON object per line, in submission order:
+//
+//	{"function":"initMarble","args":["marble1","blue","35","tom"],"invoker":"User1","invokerMspId":"Org1MSP","timestamp":1700000000000}
+//
+// timestamp is either milliseconds since the epoch, as recorded by a Caliper worker, or an
+// RFC 3339 string. invokerMspId defaults to -msp; transient maps string keys to string values;
+// txId is optional. Each transaction is committed in its own block if it succeeds, so a trace
+// always replays to the same final state. The report ends with a digest of every key, and with
+// the marbles digest of computeStateDigest("", "", "true"), which can be compared with the same
+// query on the peers of a network that ran the workload. Marbles record the ID and timestamp of
+// the transaction that last changed them, so the trace must carry txId and timestamp for the
+// digests to agree.
+//
+// With -block-size, the trace is instead ordered into blocks of that size and validated as a
+// committing peer does (see internal/mvccsim), which predicts the MVCC and phantom read
+// conflicts of the workload. -endorsement-lag models transactions endorsed that many blocks
+// before their own block is committed, as with a high Caliper send rate.
+//
+// go run ./cmd/marbles-replay -trace round.jsonl
+// go run ./cmd/marbles-replay -trace round.jsonl -json > report.json
+// go run ./cmd/marbles-replay -trace round.jsonl -block-size 10 -endorsement-lag 1
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-samples/chaincode/marbles02/go/chaincode"
+	"github.com/hyperledger/fabric-samples/chaincode/marbles02/go/internal/memstub"
+	"github.com/hyperledger/fabric-samples/chaincode/marbles02/go/internal/mvccsim"
+)
+
+// maxTraceLineBytes bounds the size of a single trace record
+const maxTraceLineBytes = 16 * 1024 * 1024
+
+// traceRecord is a single transaction of the trace
+type traceRecord struct {
+	Function     string            `json:"function"`
+	Args         []string          `json:"args"`
+	Invoker      string            `json:"invoker"`
+	InvokerMSPID string            `json:"invokerMspId"`
+	Transient    map[string]string `json:"transient"`
+	Timestamp    traceTimestamp    `json:"timestamp"`
+	TxID         string            `json:"txId"`
+}
+
+// traceTimestamp accepts milliseconds since the epoch or an RFC 3339 string
+type traceTimestamp struct {
+	time.Time
+}
+
+// UnmarshalJSON decodes a timestamp in either format
+func (t *traceTimestamp) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case nil:
+		t.Time = time.Time{}
+	case float64:
+		t.Time = time.UnixMilli(int64(value)).UTC()
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return err
+		}
+		t.Time = parsed
+	default:
+		return fmt.Errorf("timestamp must be a number of milliseconds or an RFC 3339 string")
+	}
+	return nil
+}
+
+// latencySummary holds latency percentiles in microseconds
+type latencySummary struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// functionReport is the replay outcome of one function
+type functionReport struct {
+	Count     int            `json:"count"`
+	Failed    int            `json:"failed"`
+	LatencyUs latencySummary `json:"latencyUs"`
+	latencies []time.Duration
+}
+
+// marblesDigest is the response of the chaincode's computeStateDigest over all marbles and
+// their color~name index entries, comparable with the same query on a peer
+type marblesDigest struct {
+	Digest       string `json:"digest"`
+	Marbles      int    `json:"marbles"`
+	IndexEntries int    `json:"indexEntries"`
+}
+
+// replayReport is the outcome of a replay
+type replayReport struct {
+	Transactions  int                        `json:"transactions"`
+	Failed        int                        `json:"failed"`
+	Functions     map[string]*functionReport `json:"functions"`
+	Failures      map[string]int             `json:"failures"` // error message to count
+	StateDigest   string                     `json:"stateDigest"`
+	StateKeys     int                        `json:"stateKeys"`
+	MarblesDigest *marblesDigest             `json:"marblesDigest"`
+}
+
+// conflictReport is the outcome of a simulation with -block-size
+type conflictReport struct {
+	*mvccsim.Report
+	ConflictRate  float64        `json:"conflictRate"`
+	StateDigest   string         `json:"stateDigest"`
+	StateKeys     int            `json:"stateKeys"`
+	MarblesDigest *marblesDigest `json:"marblesDigest"`
+}
+
+func main() {
+	tracePath := flag.String("trace", "", "trace file, one JSON transaction per line (- for stdin)")
+	defaultMSPID := flag.String("msp", "Org1MSP", "MSP of invokers without invokerMspId, and of the endorsing peer")
+	channelID := flag.String("channel", "mychannel", "channel ID reported to the chaincode")
+	jsonOutput := flag.Bool("json", false, "print the report as JSON")
+	verbose := flag.Bool("v", false, "show the chaincode's logging")
+	blockSize := flag.Int("block-size", 0, "order the trace into blocks of this size and report MVCC conflicts")
+	endorsementLag := flag.Int("endorsement-lag", 0, "blocks committed between a transaction's endorsement and its block's commit (with -block-size)")
+	flag.Parse()
+
+	if *tracePath == "" {
+		fmt.Fprintln(os.Stderr, "marbles-replay: -trace is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	input := os.Stdin
+	if *tracePath != "-" {
+		file, err := os.Open(*tracePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "marbles-replay: %s\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	// the chaincode logs to stdout; keep the report readable
+	stdout := os.Stdout
+	if !*verbose {
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "marbles-replay: %s\n", err)
+			os.Exit(1)
+		}
+		defer devNull.Close()
+		os.Stdout = devNull
+	}
+	// the private sale functions compare the invoker's MSP with the peer's
+	if os.Getenv("CORE_PEER_LOCALMSPID") == "" {
+		os.Setenv("CORE_PEER_LOCALMSPID", *defaultMSPID)
+	}
+
+	var report interface{}
+	var err error
+	if *blockSize > 0 {
+		report, err = simulate(input, *channelID, *defaultMSPID, mvccsim.Config{BlockSize: *blockSize, EndorsementLag: *endorsementLag})
+	} else {
+		report, err = replay(input, *channelID, *defaultMSPID)
+	}
+	os.Stdout = stdout
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marbles-replay: %s\n", err)
+		os.Exit(1)
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "marbles-replay: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	switch report := report.(type) {
+	case *conflictReport:
+		writeConflictReport(stdout, report)
+	case *replayReport:
+		writeReport(stdout, report)
+	}
+}
+
+// readTrace calls fn with the proposal of every transaction of the trace, in order
+func readTrace(input io.Reader, defaultMSPID string, fn func(record traceRecord, proposal memstub.Proposal)) error {
+	identities := map[string][]byte{}
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), maxTraceLineBytes)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := traceRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		if record.Function == "" {
+			return fmt.Errorf("line %d: function must be a non-empty string", line)
+		}
+
+		creator, err := invokerIdentity(identities, record, defaultMSPID)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		transient := map[string][]byte{}
+		for key, value := range record.Transient {
+			transient[key] = []byte(value)
+		}
+
+		fn(record, memstub.Proposal{
+			TxID:      record.TxID,
+			Function:  record.Function,
+			Args:      record.Args,
+			Timestamp: record.Timestamp.Time,
+			Creator:   creator,
+			Transient: transient,
+		})
+	}
+	return scanner.Err()
+}
+
+// replay runs every transaction of the trace in order and builds the report
+func replay(input io.Reader, channelID, defaultMSPID string) (*replayReport, error) {
+	cc := new(chaincode.SimpleChaincode)
+	ledger := memstub.NewLedger(channelID)
+	report := &replayReport{Functions: map[string]*functionReport{}, Failures: map[string]int{}}
+
+	err := readTrace(input, defaultMSPID, func(record traceRecord, proposal memstub.Proposal) {
+		stub := ledger.NewStub(proposal)
+		start := time.Now()
+		response := cc.Invoke(stub)
+		elapsed := time.Since(start)
+
+		functionStats, ok := report.Functions[record.Function]
+		if !ok {
+			functionStats = &functionReport{}
+			report.Functions[record.Function] = functionStats
+		}
+		report.Transactions++
+		functionStats.Count++
+		functionStats.latencies = append(functionStats.latencies, elapsed)
+		if response.Status >= shim.ERRORTHRESHOLD {
+			report.Failed++
+			functionStats.Failed++
+			report.Failures[response.Message]++
+			return
+		}
+		ledger.CommitBlock([]*memstub.Stub{stub})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, functionStats := range report.Functions {
+		functionStats.LatencyUs = summarizeLatencies(functionStats.latencies)
+	}
+	report.StateDigest, report.StateKeys = stateDigest(ledger)
+	report.MarblesDigest, err = computeMarblesDigest(cc, ledger)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// simulate orders the trace into blocks and validates them, reporting the conflicts
+func simulate(input io.Reader, channelID, defaultMSPID string, config mvccsim.Config) (*conflictReport, error) {
+	workload := []mvccsim.Transaction{}
+	err := readTrace(input, defaultMSPID, func(record traceRecord, proposal memstub.Proposal) {
+		workload = append(workload, mvccsim.Transaction{
+			Function:  proposal.Function,
+			Args:      proposal.Args,
+			Creator:   proposal.Creator,
+			Transient: proposal.Transient,
+			Timestamp: proposal.Timestamp,
+			TxID:      proposal.TxID,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cc := new(chaincode.SimpleChaincode)
+	ledger := memstub.NewLedger(channelID)
+	simulation, err := mvccsim.Simulate(cc, ledger, workload, config)
+	if err != nil {
+		return nil, err
+	}
+	report := &conflictReport{Report: simulation, ConflictRate: simulation.ConflictRate()}
+	report.StateDigest, report.StateKeys = stateDigest(ledger)
+	report.MarblesDigest, err = computeMarblesDigest(cc, ledger)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// invokerIdentity returns the serialized identity of the invoker of a record, creating it on
+// first use
+func invokerIdentity(identities map[string][]byte, record traceRecord, defaultMSPID string) ([]byte, error) {
+	mspID := record.InvokerMSPID
+	if mspID == "" {
+		mspID = defaultMSPID
+	}
+	invoker := record.Invoker
+	if invoker == "" {
+		invoker = "User1"
+	}
+	key := mspID + "/" + invoker
+	if creator, ok := identities[key]; ok {
+		return creator, nil
+	}
+	creator, err := memstub.NewIdentity(mspID, invoker, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	identities[key] = creator
+	return creator, nil
+}
+
+// summarizeLatencies computes nearest-rank percentiles
+func summarizeLatencies(latencies []time.Duration) latencySummary {
+	if len(latencies) == 0 {
+		return latencySummary{}
+	}
+	sorted := append([]time.Duration{}, latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p float64) float64 {
+		rank := int(p*float64(len(sorted))+0.5) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		if rank >= len(sorted) {
+			rank = len(sorted) - 1
+		}
+		return float64(sorted[rank].Nanoseconds()) / 1000
+	}
+	return latencySummary{P50: percentile(0.50), P90: percentile(0.90), P99: percentile(0.99), Max: percentile(1)}
+}
+
+// stateDigest hashes every committed key and value, in key order, each prefixed by its length
+func stateDigest(ledger *memstub.Ledger) (string, int) {
+	hash := sha256.New()
+	keys := 0
+	length := make([]byte, 8)
+	ledger.ForEach(func(key string, value []byte) {
+		binary.BigEndian.PutUint64(length, uint64(len(key)))
+		hash.Write(length)
+		hash.Write([]byte(key))
+		binary.BigEndian.PutUint64(length, uint64(len(value)))
+		hash.Write(length)
+		hash.Write(value)
+		keys++
+	})
+	return hex.EncodeToString(hash.Sum(nil)), keys
+}
+
+// computeMarblesDigest queries the chaincode's own digest of the marbles on the final state
+func computeMarblesDigest(cc *chaincode.SimpleChaincode, ledger *memstub.Ledger) (*marblesDigest, error) {
+	response := cc.Invoke(ledger.NewStub(memstub.Proposal{Function: "computeStateDigest", Args: []string{"", "", "true"}}))
+	if response.Status >= shim.ERRORTHRESHOLD {
+		return nil, fmt.Errorf("computeStateDigest failed: %s", response.Message)
+	}
+	digest := &marblesDigest{}
+	if err := json.Unmarshal(response.Payload, digest); err != nil {
+		return nil, err
+	}
+	return digest, nil
+}
+
+// writeReport prints the report as text
+func writeReport(w io.Writer, report *replayReport) {
+	fmt.Fprintf(w, "transactions: %d, failed: %d\n\n", report.Transactions, report.Failed)
+
+	functions := make([]string, 0, len(report.Functions))
+	for function := range report.Functions {
+		functions = append(functions, function)
+	}
+	sort.Strings(functions)
+	fmt.Fprintf(w, "%-32s %8s %8s %10s %10s %10s %10s\n", "function", "count", "failed", "p50 (us)", "p90 (us)", "p99 (us)", "max (us)")
+	for _, function := range functions {
+		stats := report.Functions[function]
+		fmt.Fprintf(w, "%-32s %8d %8d %10.1f %10.1f %10.1f %10.1f\n", function, stats.Count, stats.Failed,
+			stats.LatencyUs.P50, stats.LatencyUs.P90, stats.LatencyUs.P99, stats.LatencyUs.Max)
+	}
+
+	if len(report.Failures) > 0 {
+		messages := make([]string, 0, len(report.Failures))
+		for message := range report.Failures {
+			messages = append(messages, message)
+		}
+		sort.Slice(messages, func(i, j int) bool {
+			if report.Failures[messages[i]] != report.Failures[messages[j]] {
+				return report.Failures[messages[i]] > report.Failures[messages[j]]
+			}
+			return messages[i] < messages[j]
+		})
+		fmt.Fprintln(w, "\nfailures:")
+		for _, message := range messages {
+			fmt.Fprintf(w, "%8d  %s\n", report.Failures[message], strconv.Quote(message))
+		}
+	}
+
+	fmt.Fprintf(w, "\nstate digest: %s (%d keys)\n", report.StateDigest, report.StateKeys)
+	fmt.Fprintf(w, "marbles digest: %s (%d marbles, %d index entries)\n",
+		report.MarblesDigest.Digest, report.MarblesDigest.Marbles, report.MarblesDigest.IndexEntries)
+}
+
+// writeConflictReport prints the outcome of a simulation as text
+func writeConflictReport(w io.Writer, report *conflictReport) {
+	fmt.Fprintf(w, "transactions: %d, blocks: %d, committed: %d, conflicted: %d (%.1f%%)\n\n",
+		report.Transactions, report.Blocks, report.Committed(), report.Conflicted(), 100*report.ConflictRate)
+
+	functions := make([]string, 0, len(report.Functions))
+	for function := range report.Functions {
+		functions = append(functions, function)
+	}
+	sort.Strings(functions)
+	codes := []mvccsim.ValidationCode{mvccsim.Valid, mvccsim.MVCCReadConflict, mvccsim.PhantomReadConflict, mvccsim.EndorsementFailure}
+	fmt.Fprintf(w, "%-32s %9s %9s %9s %9s %9s\n", "function", "submitted", "valid", "mvcc", "phantom", "failed")
+	for _, function := range functions {
+		stats := report.Functions[function]
+		fmt.Fprintf(w, "%-32s %9d", function, stats.Submitted)
+		for _, code := range codes {
+			fmt.Fprintf(w, " %9d", stats.Codes[code])
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "\nstate digest: %s (%d keys)\n", report.StateDigest, report.StateKeys)
+	fmt.Fprintf(w, "marbles digest: %s (%d marbles, %d index entries)\n",
+		report.MarblesDigest.Digest, report.MarblesDigest.Marbles, report.MarblesDigest.IndexEntries)
+}
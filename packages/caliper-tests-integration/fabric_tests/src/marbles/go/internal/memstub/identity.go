@@ -0,0 +1,70 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package memstub
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/attrmgr"
+	"github.com/hyperledger/fabric-protos-go/msp"
+)
+
+// NewIdentity returns a serialized identity for Proposal.Creator, holding a self-signed
+// certificate with the given common name, organizational units and Fabric CA attributes.
+// The certificate is only meant to be read by the cid package; it does not chain to any MSP.
+func NewIdentity(mspID, commonName string, organizationalUnits []string, attrs map[string]string) ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName:         commonName,
+			OrganizationalUnit: organizationalUnits,
+		},
+		NotBefore: time.Unix(0, 0),
+		NotAfter:  time.Unix(0, 0).AddDate(100, 0, 0),
+	}
+	if len(attrs) > 0 {
+		attrsAsBytes, err := json.Marshal(&attrmgr.Attributes{Attrs: attrs})
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{
+			Id:    attrmgr.AttrOID,
+			Value: attrsAsBytes,
+		})
+	}
+
+	certAsBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certAsBytes}),
+	})
+}
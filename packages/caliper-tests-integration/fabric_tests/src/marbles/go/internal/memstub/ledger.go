@@ -0,0 +1,291 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// Package memstub provides an in-memory ledger and a chaincode stub backed by it, for running
+// chaincode outside of a Fabric network (benchmarks, fuzz tests and offline tools).
+//
+// Unlike shimtest.MockStub, the stub follows the peer's simulation semantics: reads observe
+// committed state only, writes are buffered until the transaction is committed, and every read
+// is recorded with the version it observed. Range queries, pagination, key history and private
+// data are supported. Rich queries are not, as with a LevelDB state database.
+package memstub
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Version identifies the transaction that last wrote a key
+type Version struct {
+	BlockNum uint64
+	TxNum    uint64
+}
+
+// versionedValue is a committed value and the version that wrote it
+type versionedValue struct {
+	value   []byte
+	version Version
+}
+
+// Ledger is an in-memory world state with key history. It is safe for concurrent use.
+type Ledger struct {
+	mutex     sync.RWMutex
+	channelID string
+	state     map[string]*versionedValue
+	keys      []string // sorted keys of state
+	history   map[string][]*queryresult.KeyModification
+	private   map[string]map[string]*versionedValue
+	height    uint64 // number of committed blocks
+	txCount   uint64 // number of stubs created, used to assign transaction IDs
+}
+
+// NewLedger returns an empty ledger for the given channel
+func NewLedger(channelID string) *Ledger {
+	return &Ledger{
+		channelID: channelID,
+		state:     map[string]*versionedValue{},
+		history:   map[string][]*queryresult.KeyModification{},
+		private:   map[string]map[string]*versionedValue{},
+	}
+}
+
+// ChannelID returns the channel the ledger belongs to
+func (l *Ledger) ChannelID() string {
+	return l.channelID
+}
+
+// Height returns the number of committed blocks
+func (l *Ledger) Height() uint64 {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.height
+}
+
+// NewStub returns a stub that simulates the given proposal against the current state
+func (l *Ledger) NewStub(proposal Proposal) *Stub {
+	l.mutex.Lock()
+	l.txCount++
+	txCount, height := l.txCount, l.height
+	l.mutex.Unlock()
+
+	// keep transaction IDs and timestamps deterministic when the caller does not provide them
+	if proposal.TxID == "" {
+		proposal.TxID = fmt.Sprintf("tx%d", txCount)
+	}
+	if proposal.Timestamp.IsZero() {
+		proposal.Timestamp = time.Unix(int64(height), 0).UTC()
+	}
+	return newStub(l, proposal)
+}
+
+// Init simulates an Init proposal and commits it in its own block if it succeeds
+func (l *Ledger) Init(cc shim.Chaincode, proposal Proposal) pb.Response {
+	stub := l.NewStub(proposal)
+	response := cc.Init(stub)
+	if response.Status < shim.ERRORTHRESHOLD {
+		l.CommitBlock([]*Stub{stub})
+	}
+	return response
+}
+
+// Invoke simulates an Invoke proposal and commits it in its own block if it succeeds
+func (l *Ledger) Invoke(cc shim.Chaincode, proposal Proposal) pb.Response {
+	stub := l.NewStub(proposal)
+	response := cc.Invoke(stub)
+	if response.Status < shim.ERRORTHRESHOLD {
+		l.CommitBlock([]*Stub{stub})
+	}
+	return response
+}
+
+// CommitBlock applies the write sets of the given simulated transactions, in order, as the
+// next block. The caller is responsible for any validation.
+func (l *Ledger) CommitBlock(txs []*Stub) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	blockNum := l.height
+	added := []string{}
+	deleted := false
+	for txNum, tx := range txs {
+		version := Version{BlockNum: blockNum, TxNum: uint64(txNum)}
+		timestamp := timestamppb.New(tx.proposal.Timestamp)
+		for _, write := range tx.Writes() {
+			l.history[write.Key] = append(l.history[write.Key], &queryresult.KeyModification{
+				TxId:      tx.proposal.TxID,
+				Value:     write.Value,
+				Timestamp: timestamp,
+				IsDelete:  write.IsDelete,
+			})
+			_, exists := l.state[write.Key]
+			if write.IsDelete {
+				if exists {
+					delete(l.state, write.Key)
+					deleted = true
+				}
+				continue
+			}
+			if !exists {
+				added = append(added, write.Key)
+			}
+			l.state[write.Key] = &versionedValue{value: write.Value, version: version}
+		}
+		for _, write := range tx.PrivateWrites() {
+			collection, ok := l.private[write.Collection]
+			if !ok {
+				collection = map[string]*versionedValue{}
+				l.private[write.Collection] = collection
+			}
+			if write.IsDelete {
+				delete(collection, write.Key)
+			} else {
+				collection[write.Key] = &versionedValue{value: write.Value, version: version}
+			}
+		}
+	}
+	if len(added) > 0 || deleted {
+		l.mergeKeys(added)
+	}
+	l.height++
+}
+
+// mergeKeys rebuilds the sorted key list from the previous keys and the keys added by a block,
+// dropping the keys no longer in state. A key may appear in both lists if it was deleted and
+// written again within the block.
+func (l *Ledger) mergeKeys(added []string) {
+	sort.Strings(added)
+	keys := make([]string, 0, len(l.state))
+	appendKey := func(key string) {
+		if _, ok := l.state[key]; ok && (len(keys) == 0 || keys[len(keys)-1] != key) {
+			keys = append(keys, key)
+		}
+	}
+	i := 0
+	for _, key := range l.keys {
+		for ; i < len(added) && added[i] <= key; i++ {
+			appendKey(added[i])
+		}
+		appendKey(key)
+	}
+	for ; i < len(added); i++ {
+		appendKey(added[i])
+	}
+	l.keys = keys
+}
+
+// Get returns the committed value of a key and its version, or nil if the key does not exist
+func (l *Ledger) Get(key string) ([]byte, *Version) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	entry, ok := l.state[key]
+	if !ok {
+		return nil, nil
+	}
+	version := entry.version
+	return entry.value, &version
+}
+
+// Range returns the committed keys in [startKey, endKey) with their values and versions.
+// An empty endKey means no upper bound.
+func (l *Ledger) Range(startKey, endKey string) []KeyValue {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.scan(startKey, endKey, 0)
+}
+
+// scan returns up to limit keys in [startKey, endKey), or every key if limit is 0.
+// The caller must hold the lock.
+func (l *Ledger) scan(startKey, endKey string, limit int) []KeyValue {
+	results := []KeyValue{}
+	for i := sort.SearchStrings(l.keys, startKey); i < len(l.keys); i++ {
+		key := l.keys[i]
+		if endKey != "" && key >= endKey {
+			break
+		}
+		if limit > 0 && len(results) == limit {
+			break
+		}
+		entry := l.state[key]
+		results = append(results, KeyValue{Key: key, Value: entry.value, Version: entry.version})
+	}
+	return results
+}
+
+// ForEach calls fn for every committed key, including composite keys, in key order
+func (l *Ledger) ForEach(fn func(key string, value []byte)) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	for _, key := range l.keys {
+		fn(key, l.state[key].value)
+	}
+}
+
+// keyHistory returns the committed modifications of a key, newest first as returned by the peer
+func (l *Ledger) keyHistory(key string) []*queryresult.KeyModification {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	modifications := l.history[key]
+	newestFirst := make([]*queryresult.KeyModification, len(modifications))
+	for i, modification := range modifications {
+		newestFirst[len(modifications)-1-i] = modification
+	}
+	return newestFirst
+}
+
+// getPrivate returns the committed value of a private data key
+func (l *Ledger) getPrivate(collection, key string) []byte {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	if entry, ok := l.private[collection][key]; ok {
+		return entry.value
+	}
+	return nil
+}
+
+// getPrivateHash returns the hash of the committed value of a private data key
+func (l *Ledger) getPrivateHash(collection, key string) []byte {
+	value := l.getPrivate(collection, key)
+	if value == nil {
+		return nil
+	}
+	hash := sha256.Sum256(value)
+	return hash[:]
+}
+
+// rangePrivate returns the committed private data keys in [startKey, endKey)
+func (l *Ledger) rangePrivate(collection, startKey, endKey string) []KeyValue {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	keys := make([]string, 0, len(l.private[collection]))
+	for key := range l.private[collection] {
+		if key >= startKey && (endKey == "" || key < endKey) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	results := make([]KeyValue, 0, len(keys))
+	for _, key := range keys {
+		entry := l.private[collection][key]
+		results = append(results, KeyValue{Key: key, Value: entry.value, Version: entry.version})
+	}
+	return results
+}
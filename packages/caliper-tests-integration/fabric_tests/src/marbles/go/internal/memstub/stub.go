@@ -0,0 +1,586 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package memstub
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	compositeKeyNamespace = "\x00"
+	minUnicodeRuneValue   = 0
+	maxUnicodeRuneValue   = utf8.MaxRune
+
+	// emptyKeySubstitute replaces an empty range start key, as done by the shim
+	emptyKeySubstitute = "\x01"
+)
+
+// ErrRichQueryUnsupported is returned by the rich query functions of the stub
+var ErrRichQueryUnsupported = errors.New("rich queries are not supported by the in-memory state database")
+
+// Proposal describes the transaction simulated by a stub
+type Proposal struct {
+	TxID      string // defaults to a sequence number assigned by the ledger
+	Function  string
+	Args      []string
+	Timestamp time.Time // defaults to a time derived from the ledger height
+	Creator   []byte    // serialized identity, see NewIdentity
+	Transient map[string][]byte
+}
+
+// KeyValue is a committed key, its value and the version that wrote it
+type KeyValue struct {
+	Key     string
+	Value   []byte
+	Version Version
+}
+
+// Read is a key read during simulation and the version observed, nil if the key did not exist
+type Read struct {
+	Key     string
+	Version *Version
+}
+
+// RangeQuery is a range read during simulation and the keys it returned
+type RangeQuery struct {
+	StartKey  string
+	EndKey    string
+	Paginated bool // Reads holds a single page of the range
+	Reads     []Read
+}
+
+// Write is a key written or deleted during simulation
+type Write struct {
+	Key      string
+	Value    []byte
+	IsDelete bool
+}
+
+// PrivateWrite is a private data key written or deleted during simulation
+type PrivateWrite struct {
+	Collection string
+	Write
+}
+
+// Stub implements shim.ChaincodeStubInterface for a single transaction against a Ledger.
+// A stub is not safe for concurrent use.
+type Stub struct {
+	ledger        *Ledger
+	proposal      Proposal
+	reads         map[string]*Version
+	readOrder     []string
+	rangeQueries  []RangeQuery
+	writes        map[string]*Write
+	privateWrites map[string]map[string]*Write
+	event         *pb.ChaincodeEvent
+}
+
+var _ shim.ChaincodeStubInterface = (*Stub)(nil)
+
+func newStub(ledger *Ledger, proposal Proposal) *Stub {
+	return &Stub{
+		ledger:        ledger,
+		proposal:      proposal,
+		reads:         map[string]*Version{},
+		writes:        map[string]*Write{},
+		privateWrites: map[string]map[string]*Write{},
+	}
+}
+
+// Reads returns the keys read by the transaction, in the order they were first read
+func (s *Stub) Reads() []Read {
+	reads := make([]Read, 0, len(s.readOrder))
+	for _, key := range s.readOrder {
+		reads = append(reads, Read{Key: key, Version: s.reads[key]})
+	}
+	return reads
+}
+
+// RangeQueries returns the range queries executed by the transaction
+func (s *Stub) RangeQueries() []RangeQuery {
+	return s.rangeQueries
+}
+
+// Writes returns the write set of the transaction, sorted by key
+func (s *Stub) Writes() []Write {
+	writes := make([]Write, 0, len(s.writes))
+	for _, write := range s.writes {
+		writes = append(writes, *write)
+	}
+	sort.Slice(writes, func(i, j int) bool { return writes[i].Key < writes[j].Key })
+	return writes
+}
+
+// PrivateWrites returns the private data write set of the transaction, sorted by collection and key
+func (s *Stub) PrivateWrites() []PrivateWrite {
+	writes := []PrivateWrite{}
+	for collection, collectionWrites := range s.privateWrites {
+		for _, write := range collectionWrites {
+			writes = append(writes, PrivateWrite{Collection: collection, Write: *write})
+		}
+	}
+	sort.Slice(writes, func(i, j int) bool {
+		if writes[i].Collection != writes[j].Collection {
+			return writes[i].Collection < writes[j].Collection
+		}
+		return writes[i].Key < writes[j].Key
+	})
+	return writes
+}
+
+// Event returns the event set by the transaction, if any
+func (s *Stub) Event() *pb.ChaincodeEvent {
+	return s.event
+}
+
+// recordRead adds a key to the read set, keeping the version of the first read
+func (s *Stub) recordRead(key string, version *Version) {
+	if _, ok := s.reads[key]; ok {
+		return
+	}
+	s.reads[key] = version
+	s.readOrder = append(s.readOrder, key)
+}
+
+// GetArgs returns the function name followed by the arguments
+func (s *Stub) GetArgs() [][]byte {
+	args := make([][]byte, 0, len(s.proposal.Args)+1)
+	args = append(args, []byte(s.proposal.Function))
+	for _, arg := range s.proposal.Args {
+		args = append(args, []byte(arg))
+	}
+	return args
+}
+
+// GetStringArgs returns the function name followed by the arguments
+func (s *Stub) GetStringArgs() []string {
+	return append([]string{s.proposal.Function}, s.proposal.Args...)
+}
+
+// GetFunctionAndParameters returns the function name and the arguments
+func (s *Stub) GetFunctionAndParameters() (string, []string) {
+	return s.proposal.Function, append([]string{}, s.proposal.Args...)
+}
+
+// GetArgsSlice returns the concatenated function name and arguments
+func (s *Stub) GetArgsSlice() ([]byte, error) {
+	slice := []byte{}
+	for _, arg := range s.GetArgs() {
+		slice = append(slice, arg...)
+	}
+	return slice, nil
+}
+
+// GetTxID returns the transaction ID
+func (s *Stub) GetTxID() string {
+	return s.proposal.TxID
+}
+
+// GetChannelID returns the channel of the ledger
+func (s *Stub) GetChannelID() string {
+	return s.ledger.channelID
+}
+
+// InvokeChaincode is not supported
+func (s *Stub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) pb.Response {
+	return shim.Error("chaincode to chaincode invocation is not supported by the in-memory stub")
+}
+
+// GetState returns the committed value of a key. Writes of the transaction itself are not
+// visible, as on a peer.
+func (s *Stub) GetState(key string) ([]byte, error) {
+	value, version := s.ledger.Get(key)
+	s.recordRead(key, version)
+	return value, nil
+}
+
+// PutState buffers a write
+func (s *Stub) PutState(key string, value []byte) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	s.writes[key] = &Write{Key: key, Value: append([]byte{}, value...)}
+	return nil
+}
+
+// DelState buffers a delete
+func (s *Stub) DelState(key string) error {
+	s.writes[key] = &Write{Key: key, IsDelete: true}
+	return nil
+}
+
+// SetStateValidationParameter is accepted and ignored
+func (s *Stub) SetStateValidationParameter(key string, ep []byte) error {
+	return nil
+}
+
+// GetStateValidationParameter always returns no validation parameter
+func (s *Stub) GetStateValidationParameter(key string) ([]byte, error) {
+	return nil, nil
+}
+
+// rangeQuery reads up to limit committed keys in [startKey, endKey) and records the query.
+// The bookmark is the key following the last result, or empty if the range is exhausted.
+func (s *Stub) rangeQuery(startKey, endKey string, limit int) ([]KeyValue, string) {
+	scanLimit := 0
+	if limit > 0 {
+		// fetch one more result to find the bookmark of the next page
+		scanLimit = limit + 1
+	}
+	s.ledger.mutex.RLock()
+	results := s.ledger.scan(startKey, endKey, scanLimit)
+	s.ledger.mutex.RUnlock()
+
+	bookmark := ""
+	if limit > 0 && len(results) > limit {
+		bookmark = results[limit].Key
+		results = results[:limit]
+	}
+
+	query := RangeQuery{StartKey: startKey, EndKey: endKey, Paginated: limit > 0, Reads: make([]Read, 0, len(results))}
+	for _, result := range results {
+		version := result.Version
+		query.Reads = append(query.Reads, Read{Key: result.Key, Version: &version})
+	}
+	s.rangeQueries = append(s.rangeQueries, query)
+	return results, bookmark
+}
+
+// validateKey rejects the keys refused by the peer's state database
+func validateKey(key string) error {
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+	if !utf8.ValidString(key) {
+		return fmt.Errorf("invalid key [%x], must be a UTF-8 string", key)
+	}
+	return nil
+}
+
+// validateSimpleKeys rejects keys in the composite key namespace
+func validateSimpleKeys(keys ...string) error {
+	for _, key := range keys {
+		if len(key) > 0 && key[0] == compositeKeyNamespace[0] {
+			return fmt.Errorf("first character of the key [%s] contains a null character which is not allowed", key)
+		}
+	}
+	return nil
+}
+
+// GetStateByRange returns the committed keys in [startKey, endKey)
+func (s *Stub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	if err := validateSimpleKeys(startKey, endKey); err != nil {
+		return nil, err
+	}
+	results, _ := s.rangeQuery(startKey, endKey, 0)
+	return newStateIterator(results), nil
+}
+
+// GetStateByRangeWithPagination returns a page of the committed keys in [startKey, endKey)
+func (s *Stub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32,
+	bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	if err := validateSimpleKeys(startKey, endKey); err != nil {
+		return nil, nil, err
+	}
+	return s.paginatedRangeQuery(startKey, endKey, pageSize, bookmark)
+}
+
+// paginatedRangeQuery resumes a range query from a bookmark
+func (s *Stub) paginatedRangeQuery(startKey, endKey string, pageSize int32,
+	bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if pageSize <= 0 {
+		return nil, nil, errors.New("pageSize must be greater than zero")
+	}
+	if bookmark != "" {
+		if bookmark < startKey || (endKey != "" && bookmark >= endKey) {
+			return nil, nil, fmt.Errorf("bookmark [%s] is outside of the queried range", bookmark)
+		}
+		startKey = bookmark
+	}
+	results, next := s.rangeQuery(startKey, endKey, int(pageSize))
+	metadata := &pb.QueryResponseMetadata{FetchedRecordsCount: int32(len(results)), Bookmark: next}
+	return newStateIterator(results), metadata, nil
+}
+
+// partialCompositeKeyRange returns the key range of the composite keys sharing a prefix
+func (s *Stub) partialCompositeKeyRange(objectType string, attributes []string) (string, string, error) {
+	partialCompositeKey, err := s.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return "", "", err
+	}
+	return partialCompositeKey, partialCompositeKey + string(rune(maxUnicodeRuneValue)), nil
+}
+
+// GetStateByPartialCompositeKey returns the committed composite keys sharing a prefix
+func (s *Stub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	startKey, endKey, err := s.partialCompositeKeyRange(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	results, _ := s.rangeQuery(startKey, endKey, 0)
+	return newStateIterator(results), nil
+}
+
+// GetStateByPartialCompositeKeyWithPagination returns a page of the committed composite keys
+// sharing a prefix
+func (s *Stub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string,
+	pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	startKey, endKey, err := s.partialCompositeKeyRange(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.paginatedRangeQuery(startKey, endKey, pageSize, bookmark)
+}
+
+// CreateCompositeKey combines an object type and attributes into a composite key
+func (s *Stub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return shim.CreateCompositeKey(objectType, attributes)
+}
+
+// SplitCompositeKey splits a composite key into its object type and attributes
+func (s *Stub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	components := []string{}
+	componentIndex := 1
+	for i := 1; i < len(compositeKey); i++ {
+		if compositeKey[i] == minUnicodeRuneValue {
+			components = append(components, compositeKey[componentIndex:i])
+			componentIndex = i + 1
+		}
+	}
+	if len(components) == 0 {
+		return "", nil, fmt.Errorf("[%s] is not a composite key", compositeKey)
+	}
+	return components[0], components[1:], nil
+}
+
+// GetQueryResult is not supported
+func (s *Stub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, ErrRichQueryUnsupported
+}
+
+// GetQueryResultWithPagination is not supported
+func (s *Stub) GetQueryResultWithPagination(query string, pageSize int32,
+	bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	return nil, nil, ErrRichQueryUnsupported
+}
+
+// GetHistoryForKey returns the committed modifications of a key, newest first
+func (s *Stub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &historyIterator{results: s.ledger.keyHistory(key)}, nil
+}
+
+// GetPrivateData returns the committed value of a private data key
+func (s *Stub) GetPrivateData(collection, key string) ([]byte, error) {
+	if collection == "" {
+		return nil, errors.New("collection must not be an empty string")
+	}
+	return s.ledger.getPrivate(collection, key), nil
+}
+
+// GetPrivateDataHash returns the SHA-256 hash of the committed value of a private data key
+func (s *Stub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	if collection == "" {
+		return nil, errors.New("collection must not be an empty string")
+	}
+	return s.ledger.getPrivateHash(collection, key), nil
+}
+
+// putPrivateWrite buffers a private data write or delete
+func (s *Stub) putPrivateWrite(collection string, write *Write) error {
+	if collection == "" {
+		return errors.New("collection must not be an empty string")
+	}
+	if _, ok := s.privateWrites[collection]; !ok {
+		s.privateWrites[collection] = map[string]*Write{}
+	}
+	s.privateWrites[collection][write.Key] = write
+	return nil
+}
+
+// PutPrivateData buffers a private data write
+func (s *Stub) PutPrivateData(collection string, key string, value []byte) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	return s.putPrivateWrite(collection, &Write{Key: key, Value: append([]byte{}, value...)})
+}
+
+// DelPrivateData buffers a private data delete
+func (s *Stub) DelPrivateData(collection, key string) error {
+	return s.putPrivateWrite(collection, &Write{Key: key, IsDelete: true})
+}
+
+// PurgePrivateData buffers a private data delete; the in-memory ledger keeps no private history
+func (s *Stub) PurgePrivateData(collection, key string) error {
+	return s.DelPrivateData(collection, key)
+}
+
+// SetPrivateDataValidationParameter is accepted and ignored
+func (s *Stub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	return nil
+}
+
+// GetPrivateDataValidationParameter always returns no validation parameter
+func (s *Stub) GetPrivateDataValidationParameter(collection, key string) ([]byte, error) {
+	return nil, nil
+}
+
+// GetPrivateDataByRange returns the committed private data keys in [startKey, endKey)
+func (s *Stub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if collection == "" {
+		return nil, errors.New("collection must not be an empty string")
+	}
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	if err := validateSimpleKeys(startKey, endKey); err != nil {
+		return nil, err
+	}
+	return newStateIterator(s.ledger.rangePrivate(collection, startKey, endKey)), nil
+}
+
+// GetPrivateDataByPartialCompositeKey returns the committed private data composite keys
+// sharing a prefix
+func (s *Stub) GetPrivateDataByPartialCompositeKey(collection, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	if collection == "" {
+		return nil, errors.New("collection must not be an empty string")
+	}
+	startKey, endKey, err := s.partialCompositeKeyRange(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return newStateIterator(s.ledger.rangePrivate(collection, startKey, endKey)), nil
+}
+
+// GetPrivateDataQueryResult is not supported
+func (s *Stub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, ErrRichQueryUnsupported
+}
+
+// GetCreator returns the serialized identity of the proposal
+func (s *Stub) GetCreator() ([]byte, error) {
+	return s.proposal.Creator, nil
+}
+
+// GetTransient returns the transient map of the proposal
+func (s *Stub) GetTransient() (map[string][]byte, error) {
+	if s.proposal.Transient == nil {
+		return map[string][]byte{}, nil
+	}
+	return s.proposal.Transient, nil
+}
+
+// GetBinding returns no binding
+func (s *Stub) GetBinding() ([]byte, error) {
+	return nil, nil
+}
+
+// GetDecorations returns no decorations
+func (s *Stub) GetDecorations() map[string][]byte {
+	return nil
+}
+
+// GetSignedProposal is not supported
+func (s *Stub) GetSignedProposal() (*pb.SignedProposal, error) {
+	return nil, errors.New("signed proposals are not available from the in-memory stub")
+}
+
+// GetTxTimestamp returns the timestamp of the proposal
+func (s *Stub) GetTxTimestamp() (*timestamppb.Timestamp, error) {
+	return timestamppb.New(s.proposal.Timestamp), nil
+}
+
+// SetEvent sets the event of the transaction, replacing any previous one
+func (s *Stub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("event name can not be empty string")
+	}
+	s.event = &pb.ChaincodeEvent{EventName: name, Payload: payload, TxId: s.proposal.TxID}
+	return nil
+}
+
+// stateIterator iterates over a snapshot of range query results
+type stateIterator struct {
+	results []KeyValue
+	next    int
+}
+
+func newStateIterator(results []KeyValue) *stateIterator {
+	return &stateIterator{results: results}
+}
+
+// HasNext returns whether more results are available
+func (it *stateIterator) HasNext() bool {
+	return it.next < len(it.results)
+}
+
+// Next returns the next result
+func (it *stateIterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, errors.New("no more results")
+	}
+	result := it.results[it.next]
+	it.next++
+	return &queryresult.KV{Key: result.Key, Value: result.Value}, nil
+}
+
+// Close releases the iterator
+func (it *stateIterator) Close() error {
+	return nil
+}
+
+// historyIterator iterates over the modifications of a key
+type historyIterator struct {
+	results []*queryresult.KeyModification
+	next    int
+}
+
+// HasNext returns whether more modifications are available
+func (it *historyIterator) HasNext() bool {
+	return it.next < len(it.results)
+}
+
+// Next returns the next modification
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if !it.HasNext() {
+		return nil, errors.New("no more results")
+	}
+	result := it.results[it.next]
+	it.next++
+	return result, nil
+}
+
+// Close releases the iterator
+func (it *historyIterator) Close() error {
+	return nil
+}
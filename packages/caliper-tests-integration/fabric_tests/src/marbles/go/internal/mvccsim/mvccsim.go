@@ -0,0 +1,306 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// Package mvccsim simulates Fabric's execute-order-validate pipeline for a chaincode, to predict
+// the MVCC conflict rate of a workload without a network.
+//
+// Transactions are endorsed against a snapshot of the committed state to collect their read and
+// write sets, ordered into blocks of a fixed size, and validated as a committing peer does:
+// a transaction is invalidated if a key it read, or the result of a range query it executed,
+// was changed by a committed block or by an earlier valid transaction of the same block.
+// Endorsement can lag behind commit by a number of blocks, to model transactions that are still
+// in flight while earlier blocks are committed, as happens when a Caliper worker submits
+// transactions at a high rate. Private data reads are not validated.
+//
+// cmd/marbles-replay runs a recorded Caliper trace through Simulate with its -block-size flag.
+package mvccsim
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-samples/chaincode/marbles02/go/internal/memstub"
+)
+
+// ValidationCode is the outcome of a simulated transaction
+type ValidationCode string
+
+// Validation codes, named after the peer's transaction validation codes
+const (
+	Valid               ValidationCode = "VALID"
+	MVCCReadConflict    ValidationCode = "MVCC_READ_CONFLICT"
+	PhantomReadConflict ValidationCode = "PHANTOM_READ_CONFLICT"
+	EndorsementFailure  ValidationCode = "ENDORSEMENT_FAILURE" // the endorsement returned an error; never ordered
+)
+
+// Transaction is a chaincode invocation of the workload
+type Transaction struct {
+	Function  string            `json:"function"`
+	Args      []string          `json:"args"`
+	Creator   []byte            `json:"creator,omitempty"` // serialized identity, see memstub.NewIdentity
+	Transient map[string][]byte `json:"transient,omitempty"`
+	Timestamp time.Time         `json:"timestamp"` // defaults to a time derived from the ledger height
+	TxID      string            `json:"txId,omitempty"`
+}
+
+// Config controls how the workload is ordered
+type Config struct {
+	// BlockSize is the maximum number of transactions per block, as the orderer's
+	// BatchSize.MaxMessageCount
+	BlockSize int
+
+	// EndorsementLag is the number of blocks committed between the endorsement of a
+	// transaction and the commit of its block. With 0, every block is endorsed against the
+	// state left by the previous block.
+	EndorsementLag int
+}
+
+// TxResult is the outcome of a single transaction
+type TxResult struct {
+	Index       int            `json:"index"` // position in the workload
+	TxID        string         `json:"txId"`
+	Function    string         `json:"function"`
+	Block       uint64         `json:"block"` // block the transaction was ordered in
+	Code        ValidationCode `json:"code"`
+	ConflictKey string         `json:"conflictKey,omitempty"` // key or range start that caused the conflict
+	Message     string         `json:"message,omitempty"`     // chaincode error of an endorsement failure
+}
+
+// FunctionStats counts the outcomes of the transactions of one function
+type FunctionStats struct {
+	Submitted int                    `json:"submitted"`
+	Codes     map[ValidationCode]int `json:"codes"`
+}
+
+// Report summarizes a simulation
+type Report struct {
+	Transactions int                       `json:"transactions"`
+	Blocks       int                       `json:"blocks"`
+	Codes        map[ValidationCode]int    `json:"codes"`
+	Functions    map[string]*FunctionStats `json:"functions"`
+	Results      []TxResult                `json:"results"`
+}
+
+// Committed returns the number of valid transactions
+func (r *Report) Committed() int {
+	return r.Codes[Valid]
+}
+
+// Conflicted returns the number of transactions invalidated by MVCC validation
+func (r *Report) Conflicted() int {
+	return r.Codes[MVCCReadConflict] + r.Codes[PhantomReadConflict]
+}
+
+// ConflictRate returns the share of ordered transactions invalidated by MVCC validation
+func (r *Report) ConflictRate() float64 {
+	ordered := r.Committed() + r.Conflicted()
+	if ordered == 0 {
+		return 0
+	}
+	return float64(r.Conflicted()) / float64(ordered)
+}
+
+// add records the outcome of a transaction
+func (r *Report) add(result TxResult) {
+	r.Results = append(r.Results, result)
+	r.Codes[result.Code]++
+	stats, ok := r.Functions[result.Function]
+	if !ok {
+		stats = &FunctionStats{Codes: map[ValidationCode]int{}}
+		r.Functions[result.Function] = stats
+	}
+	stats.Submitted++
+	stats.Codes[result.Code]++
+}
+
+// endorsed is a transaction simulated against a snapshot
+type endorsed struct {
+	index int
+	tx    Transaction
+	stub  *memstub.Stub
+	err   string
+}
+
+// Simulate runs the workload against the ledger, which is updated with the valid transactions
+func Simulate(cc shim.Chaincode, ledger *memstub.Ledger, workload []Transaction, config Config) (*Report, error) {
+	if config.BlockSize <= 0 {
+		return nil, fmt.Errorf("block size must be positive")
+	}
+	if config.EndorsementLag < 0 {
+		return nil, fmt.Errorf("endorsement lag must not be negative")
+	}
+
+	report := &Report{
+		Transactions: len(workload),
+		Codes:        map[ValidationCode]int{},
+		Functions:    map[string]*FunctionStats{},
+		Results:      []TxResult{},
+	}
+
+	// split the workload into blocks, then endorse each block EndorsementLag blocks ahead of
+	// its commit
+	batches := [][]int{}
+	for start := 0; start < len(workload); start += config.BlockSize {
+		end := start + config.BlockSize
+		if end > len(workload) {
+			end = len(workload)
+		}
+		batch := make([]int, 0, end-start)
+		for i := start; i < end; i++ {
+			batch = append(batch, i)
+		}
+		batches = append(batches, batch)
+	}
+
+	endorsedBatches := make([][]*endorsed, len(batches))
+	endorseBatch := func(b int) {
+		for _, i := range batches[b] {
+			endorsedBatches[b] = append(endorsedBatches[b], endorse(cc, ledger, i, workload[i]))
+		}
+	}
+	for b := 0; b <= config.EndorsementLag && b < len(batches); b++ {
+		endorseBatch(b)
+	}
+	for b := range batches {
+		report.Blocks++
+		validateAndCommit(ledger, endorsedBatches[b], report)
+		endorsedBatches[b] = nil
+		if next := b + config.EndorsementLag + 1; next < len(batches) {
+			endorseBatch(next)
+		}
+	}
+	return report, nil
+}
+
+// endorse simulates a transaction against the current committed state
+func endorse(cc shim.Chaincode, ledger *memstub.Ledger, index int, tx Transaction) *endorsed {
+	stub := ledger.NewStub(memstub.Proposal{
+		TxID:      tx.TxID,
+		Timestamp: tx.Timestamp,
+		Function:  tx.Function,
+		Args:      tx.Args,
+		Creator:   tx.Creator,
+		Transient: tx.Transient,
+	})
+	response := cc.Invoke(stub)
+	result := &endorsed{index: index, tx: tx, stub: stub}
+	if response.Status >= shim.ERRORTHRESHOLD {
+		result.err = response.Message
+	} else if paginatedUpdate(stub) {
+		// the peer refuses paginated queries in transactions that write, at endorsement
+		result.err = "paginated queries are not supported in transactions that write"
+	}
+	return result
+}
+
+// paginatedUpdate reports whether a transaction both executed a paginated query and wrote
+func paginatedUpdate(stub *memstub.Stub) bool {
+	if len(stub.Writes()) == 0 {
+		return false
+	}
+	for _, query := range stub.RangeQueries() {
+		if query.Paginated {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAndCommit validates the transactions of a block in order and commits the valid ones.
+// Transactions whose endorsement failed are reported without being ordered.
+func validateAndCommit(ledger *memstub.Ledger, block []*endorsed, report *Report) {
+	blockNum := ledger.Height()
+	updated := map[string]bool{} // keys written by earlier valid transactions of the block
+	valid := []*memstub.Stub{}
+
+	for _, tx := range block {
+		result := TxResult{Index: tx.index, TxID: tx.stub.GetTxID(), Function: tx.tx.Function, Block: blockNum}
+		if tx.err != "" {
+			result.Code = EndorsementFailure
+			result.Message = tx.err
+			report.add(result)
+			continue
+		}
+
+		result.Code, result.ConflictKey = validate(ledger, tx.stub, updated)
+		report.add(result)
+		if result.Code != Valid {
+			continue
+		}
+		for _, write := range tx.stub.Writes() {
+			updated[write.Key] = true
+		}
+		valid = append(valid, tx.stub)
+	}
+	ledger.CommitBlock(valid)
+}
+
+// validate checks the read set and range queries of a transaction against the committed state
+// and the updates of the earlier valid transactions of its block
+func validate(ledger *memstub.Ledger, stub *memstub.Stub, updated map[string]bool) (ValidationCode, string) {
+	for _, read := range stub.Reads() {
+		if updated[read.Key] {
+			return MVCCReadConflict, read.Key
+		}
+		if _, version := ledger.Get(read.Key); !sameVersion(version, read.Version) {
+			return MVCCReadConflict, read.Key
+		}
+	}
+
+	for _, query := range stub.RangeQueries() {
+		if query.Paginated {
+			// only the returned keys of a page are validated
+			for _, read := range query.Reads {
+				if _, version := ledger.Get(read.Key); updated[read.Key] || !sameVersion(version, read.Version) {
+					return MVCCReadConflict, read.Key
+				}
+			}
+			continue
+		}
+		if phantom(ledger, query, updated) {
+			return PhantomReadConflict, query.StartKey
+		}
+	}
+	return Valid, ""
+}
+
+// phantom re-executes a range query and reports whether its results changed
+func phantom(ledger *memstub.Ledger, query memstub.RangeQuery, updated map[string]bool) bool {
+	current := ledger.Range(query.StartKey, query.EndKey)
+	if len(current) != len(query.Reads) {
+		return true
+	}
+	for i, kv := range current {
+		version := kv.Version
+		if kv.Key != query.Reads[i].Key || !sameVersion(&version, query.Reads[i].Version) {
+			return true
+		}
+	}
+	// any update of the block within the range changes the results
+	for key := range updated {
+		if key >= query.StartKey && (query.EndKey == "" || key < query.EndKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameVersion compares two optional versions
+func sameVersion(a, b *memstub.Version) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
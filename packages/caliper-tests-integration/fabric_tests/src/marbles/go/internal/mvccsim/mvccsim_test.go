@@ -0,0 +1,195 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package mvccsim
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric-samples/chaincode/marbles02/go/chaincode"
+	"github.com/hyperledger/fabric-samples/chaincode/marbles02/go/internal/memstub"
+)
+
+// newSimLedger returns a ledger holding two blue marbles and a red one
+func newSimLedger(t *testing.T, cc shim.Chaincode) *memstub.Ledger {
+	ledger := memstub.NewLedger("sim")
+	for _, args := range [][]string{
+		{"marble1", "blue", "35", "tom"},
+		{"marble2", "blue", "50", "tom"},
+		{"marble3", "red", "70", "jerry"},
+	} {
+		response := ledger.Invoke(cc, memstub.Proposal{Function: "initMarble", Args: args})
+		if response.Status != shim.OK {
+			t.Fatalf("initMarble failed: %s", response.Message)
+		}
+	}
+	return ledger
+}
+
+// simulate runs a workload against a fresh ledger with the chaincode's logging silenced
+func simulate(t *testing.T, workload []Transaction, config Config) *Report {
+	return simulateChaincode(t, new(chaincode.SimpleChaincode), workload, config)
+}
+
+// simulateChaincode runs a workload of a chaincode against a fresh ledger with logging silenced
+func simulateChaincode(t *testing.T, cc shim.Chaincode, workload []Transaction, config Config) *Report {
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = devNull
+	defer func() {
+		os.Stdout = stdout
+		devNull.Close()
+	}()
+
+	report, err := Simulate(cc, newSimLedger(t, cc), workload, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// codes returns the validation codes of a report in workload order
+func codes(report *Report) []ValidationCode {
+	result := make([]ValidationCode, len(report.Results))
+	for _, r := range report.Results {
+		result[r.Index] = r.Code
+	}
+	return result
+}
+
+func checkCodes(t *testing.T, report *Report, expected ...ValidationCode) {
+	t.Helper()
+	actual := codes(report)
+	if len(actual) != len(expected) {
+		t.Fatalf("got %d results, expected %d", len(actual), len(expected))
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("transaction %d (%s): got %s, expected %s (conflict key %q)",
+				i, report.Results[i].Function, actual[i], expected[i], report.Results[i].ConflictKey)
+		}
+	}
+}
+
+func TestConcurrentColorTransfersConflict(t *testing.T) {
+	// both transfers read and write the blue marbles; the second one endorsed the state the
+	// first one changes
+	report := simulate(t, []Transaction{
+		{Function: "transferMarblesBasedOnColor", Args: []string{"blue", "jerry"}},
+		{Function: "transferMarblesBasedOnColor", Args: []string{"blue", "bob"}},
+	}, Config{BlockSize: 2})
+
+	checkCodes(t, report, Valid, MVCCReadConflict)
+	if report.Blocks != 1 {
+		t.Errorf("got %d blocks, expected 1", report.Blocks)
+	}
+	if rate := report.ConflictRate(); rate != 0.5 {
+		t.Errorf("got conflict rate %v, expected 0.5", rate)
+	}
+}
+
+func TestColorTransferPhantomRead(t *testing.T) {
+	// the new blue marble enters the color~name range the transfer queried
+	report := simulate(t, []Transaction{
+		{Function: "initMarble", Args: []string{"marble4", "blue", "10", "bob"}},
+		{Function: "transferMarblesBasedOnColor", Args: []string{"blue", "jerry"}},
+	}, Config{BlockSize: 2})
+
+	checkCodes(t, report, Valid, PhantomReadConflict)
+}
+
+func TestSeparateBlocksDoNotConflict(t *testing.T) {
+	report := simulate(t, []Transaction{
+		{Function: "transferMarblesBasedOnColor", Args: []string{"blue", "jerry"}},
+		{Function: "transferMarblesBasedOnColor", Args: []string{"blue", "bob"}},
+	}, Config{BlockSize: 1})
+
+	checkCodes(t, report, Valid, Valid)
+	if report.Blocks != 2 {
+		t.Errorf("got %d blocks, expected 2", report.Blocks)
+	}
+}
+
+func TestEndorsementLag(t *testing.T) {
+	// the second block is endorsed before the first one is committed
+	report := simulate(t, []Transaction{
+		{Function: "transferMarblesBasedOnColor", Args: []string{"blue", "jerry"}},
+		{Function: "transferMarblesBasedOnColor", Args: []string{"blue", "bob"}},
+	}, Config{BlockSize: 1, EndorsementLag: 1})
+
+	checkCodes(t, report, Valid, MVCCReadConflict)
+}
+
+func TestUnrelatedTransactionsAreValid(t *testing.T) {
+	report := simulate(t, []Transaction{
+		{Function: "transferMarble", Args: []string{"marble1", "jerry"}},
+		{Function: "transferMarble", Args: []string{"marble3", "tom"}},
+		{Function: "transferMarble", Args: []string{"marble9", "tom"}},
+	}, Config{BlockSize: 3})
+
+	checkCodes(t, report, Valid, Valid, EndorsementFailure)
+	if report.Conflicted() != 0 || report.Committed() != 2 {
+		t.Errorf("got %d committed and %d conflicted, expected 2 and 0", report.Committed(), report.Conflicted())
+	}
+}
+
+// pagingChaincode adds a function to the marbles chaincode that reads a page of marbles and
+// writes, which the peer refuses at endorsement
+type pagingChaincode struct {
+	chaincode.SimpleChaincode
+}
+
+func (cc *pagingChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	if function, _ := stub.GetFunctionAndParameters(); function != "pageAndWrite" {
+		return cc.SimpleChaincode.Invoke(stub)
+	}
+	resultsIterator, _, err := stub.GetStateByRangeWithPagination("marble1", "marble9", 2, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	resultsIterator.Close()
+	if err := stub.PutState("paged", []byte{0x00}); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func TestPaginatedQueryInUpdateFailsEndorsement(t *testing.T) {
+	report := simulateChaincode(t, new(pagingChaincode), []Transaction{
+		{Function: "pageAndWrite"},
+		{Function: "getMarblesByRangeWithPagination", Args: []string{"marble1", "marble9", "2", ""}},
+	}, Config{BlockSize: 2})
+
+	checkCodes(t, report, EndorsementFailure, Valid)
+	if report.Results[0].Message == "" {
+		t.Error("the endorsement failure carries no message")
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	cc := new(chaincode.SimpleChaincode)
+	ledger := memstub.NewLedger("sim")
+	if _, err := Simulate(cc, ledger, nil, Config{}); err == nil {
+		t.Error("a zero block size was accepted")
+	}
+	if _, err := Simulate(cc, ledger, nil, Config{BlockSize: 1, EndorsementLag: -1}); err == nil {
+		t.Error("a negative endorsement lag was accepted")
+	}
+}
@@ -0,0 +1,35 @@
+/*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+// The marbles chaincode is implemented in the chaincode package, so that offline tools such as
+// cmd/marbles-replay can run it in-process. This package is the binary started by the peer.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-samples/chaincode/marbles02/go/chaincode"
+)
+
+// ===================================================================================
+// Main
+// ===================================================================================
+func main() {
+	err := shim.Start(chaincode.NewFromEnv())
+	if err != nil {
+		fmt.Printf("Error starting Simple chaincode: %s", err)
+	}
+}